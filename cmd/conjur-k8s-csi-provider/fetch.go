@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/provider"
+)
+
+// runFetch implements the fetch subcommand, which mounts secrets from Conjur
+// into a local directory to help troubleshoot a SecretProviderClass and pod
+// annotations. It returns the process exit code: 0 if the secrets were
+// written, 1 if the mount failed and 2 if the arguments were invalid.
+func runFetch(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	spcFile := flags.String("f", "", "Manifest holding the Conjur SecretProviderClass to mount")
+	podFile := flags.String("pod", "", "Manifest holding the pod or workload whose annotations list the secrets to mount")
+	secretsFile := flags.String("secrets", "", "File listing the secrets to mount, in the format of the conjur.org/secrets annotation. Replaces the pod's annotation")
+	jwtFile := flags.String("jwt", "", "File holding the JWT used to authenticate with Conjur, or \"-\" to read it from stdin")
+	certificateFile := flags.String("sslCertificate", "", "File holding the Conjur appliance certificate, replacing the SecretProviderClass's certificate")
+	outDir := flags.String("out", "", "Directory to write the mounted files to")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s fetch -f <manifest> -jwt <file> -out <directory> [-pod <manifest>] [-secrets <file>] [-sslCertificate <file>]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *spcFile == "" || *jwtFile == "" || *outDir == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	readFile := func(path string) ([]byte, error) {
+		if path == "-" {
+			return io.ReadAll(stdin)
+		}
+		return os.ReadFile(path)
+	}
+
+	opts := provider.FetchOptions{}
+	data, err := readFile(*spcFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	opts.SecretProviderClass = provider.Manifest{Name: *spcFile, Data: data}
+	if *podFile != "" {
+		data, err := readFile(*podFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		opts.Pod = &provider.Manifest{Name: *podFile, Data: data}
+	}
+	for _, file := range []struct {
+		path  string
+		value *string
+	}{
+		{*secretsFile, &opts.Secrets},
+		{*jwtFile, &opts.Token},
+		{*certificateFile, &opts.SSLCertificate},
+	} {
+		if file.path == "" {
+			continue
+		}
+		data, err := readFile(file.path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		*file.value = string(data)
+	}
+	opts.Token = strings.TrimSpace(opts.Token)
+
+	// Only log problems unless asked for more, to keep the summary readable
+	logging.SetLogLevel("warn")
+	setLogLevelFromEnv()
+
+	resp, err := provider.Fetch(context.Background(), opts)
+	if err == nil {
+		err = provider.WriteFiles(*outDir, resp.GetFiles())
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	summary := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(summary, "FILE\tMODE\tSIZE")
+	for _, file := range resp.GetFiles() {
+		fmt.Fprintf(summary, "%s\t%#o\t%d\n", file.GetPath(), file.GetMode(), len(file.GetContents()))
+	}
+	fmt.Fprintln(summary)
+
+	versions := resp.GetObjectVersion()
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].GetId() < versions[j].GetId()
+	})
+	fmt.Fprintln(summary, "SECRET\tVERSION")
+	for _, version := range versions {
+		fmt.Fprintf(summary, "%s\t%s\n", version.GetId(), version.GetVersion())
+	}
+	summary.Flush()
+	return 0
+}
@@ -1,17 +1,32 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/k8s"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/provider"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/tracing"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/webhook"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "fetch":
+			os.Exit(runFetch(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		}
+	}
+
 	// Note: This will log even if the log level is set to "warn" or "error" since that's loaded after this
 	log.Info(logmessages.CKCP001, provider.FullVersionName)
 
@@ -19,23 +34,59 @@ func main() {
 
 	healthPort := flag.Int("healthPort", provider.DefaultPort, "Port to expose Conjur Provider health server")
 	socketPath := flag.String("socketPath", provider.DefaultSocketPath, "Socket to expose Conjur Provider gRPC server")
+	requestTimeout := flag.Duration("requestTimeout", provider.DefaultRequestTimeout, "Deadline given to requests from the CSI driver which don't have one. Zero disables it")
+	maxConcurrentMounts := flag.Int("maxConcurrentMounts", provider.DefaultMaxConcurrentMounts, "Number of mount requests served at once, with the rest queued fairly across namespaces. Zero disables the limit")
+	drainTimeout := flag.Duration("drainTimeout", provider.DefaultDrainTimeout, "Time to wait for in-flight requests to complete when stopping, after which they're abandoned")
+	logFormat := flag.String("logFormat", logging.FormatText, "Format of log lines, either \"text\" or \"json\"")
+	tracingEndpoint := flag.String("tracingEndpoint", "", "Host and port of an OTLP gRPC collector to export traces to. Tracing is disabled if empty")
+	tracingInsecure := flag.Bool("tracingInsecure", false, "Connect to the OTLP collector without TLS")
+	tracingSampleRatio := flag.Float64("tracingSampleRatio", 1, "Fraction of mount requests to trace, from 0 to 1")
+	webhookMode := flag.Bool("webhook", false, "Serve the admission webhook validating Conjur pod annotations, instead of the CSI provider")
+	webhookPort := flag.Int("webhookPort", webhook.DefaultPort, "Port to expose the admission webhook")
+	tlsCertFile := flag.String("tlsCertFile", "", "Certificate used to serve the admission webhook over TLS")
+	tlsKeyFile := flag.String("tlsKeyFile", "", "Private key used to serve the admission webhook over TLS")
 	flag.Parse()
 
-	if logLevel, ok := os.LookupEnv("LOG_LEVEL"); ok {
-		switch logLevel {
-		case "debug", "info", "warn", "error":
-			log.SetLogLevel(logLevel)
-		default:
-			log.Warn(logmessages.CKCP002, logLevel)
-		}
+	if err := logging.SetFormat(*logFormat); err != nil {
+		log.Warn(logmessages.CKCP064, *logFormat)
+	}
+
+	setLogLevelFromEnv()
+
+	if *webhookMode {
+		os.Exit(runWebhook(webhook.NewServer(*webhookPort, *tlsCertFile, *tlsKeyFile)))
+	}
+
+	stopTracing, err := tracing.Start(context.Background(), tracing.Config{
+		Endpoint:    *tracingEndpoint,
+		Insecure:    *tracingInsecure,
+		SampleRatio: *tracingSampleRatio,
+	}, provider.ProviderVersion)
+	if err != nil {
+		log.Error(logmessages.CKCP066, err)
+		stopTracing = func(context.Context) error { return nil }
+	} else if *tracingEndpoint != "" {
+		log.Info(logmessages.CKCP065, *tracingEndpoint)
 	}
 
+	// The node name is provided by the Downward API, and allows the pods of
+	// the provider's own node to be cached
+	kubeClient, err := k8s.NewClient(os.Getenv("NODE_NAME"))
+	if err != nil {
+		log.Error(logmessages.CKCP063, err)
+		os.Exit(1)
+	}
+	kubeClient.Start()
+
 	var providerServer *provider.ConjurProviderServer
 	providerErr := make(chan error)
 	var healthServer *provider.HealthServer
 	healthErr := make(chan error)
 
-	providerServer = provider.NewServer(*socketPath)
+	providerServer = provider.NewServer(*socketPath, kubeClient, provider.ServerOptions{
+		RequestTimeout:      *requestTimeout,
+		MaxConcurrentMounts: *maxConcurrentMounts,
+	})
 	go func() {
 		err := providerServer.Start()
 		if err != nil {
@@ -64,12 +115,40 @@ func main() {
 	case <-stop:
 	}
 
-	err := healthServer.Stop()
+	// The provider reports not ready while in-flight requests complete, so
+	// health checks are served until it has stopped
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	providerServer.Stop(ctx)
+	cancel()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = healthServer.Stop(ctx)
+	cancel()
 	if err != nil {
 		log.Error(logmessages.CKCP005, err)
 		exitCode = 1
 	}
 
-	providerServer.Stop()
+	kubeClient.Stop()
+
+	// Flush any spans which haven't yet been exported
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	if err := stopTracing(ctx); err != nil {
+		log.Error(logmessages.CKCP067, err)
+	}
+	cancel()
 	os.Exit(exitCode)
 }
+
+// setLogLevelFromEnv sets the log level given by the LOG_LEVEL environment
+// variable, if any.
+func setLogLevelFromEnv() {
+	if logLevel, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		switch logLevel {
+		case "debug", "info", "warn", "error":
+			logging.SetLogLevel(logLevel)
+		default:
+			log.Warn(logmessages.CKCP002, logLevel)
+		}
+	}
+}
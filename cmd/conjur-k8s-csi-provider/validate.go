@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/provider"
+)
+
+// fileList is a flag which may be given more than once.
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runValidate implements the validate subcommand, which checks manifests for
+// problems which would prevent pods from mounting Conjur secrets. It returns
+// the process exit code: 0 if the manifests are valid, 1 if they're invalid
+// and 2 if they couldn't be read.
+func runValidate(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var files fileList
+	flags.Var(&files, "f", "Manifest to validate, or \"-\" to read from stdin. May be repeated")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s validate -f <manifest> [-f <manifest>...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(files) == 0 || flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	manifests := []provider.Manifest{}
+	for _, file := range files {
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		manifests = append(manifests, provider.Manifest{Name: file, Data: data})
+	}
+
+	// Problems are reported once each in the results, rather than also being
+	// logged as they're found
+	log.InfoLogger.SetOutput(io.Discard)
+	log.ErrorLogger.SetOutput(io.Discard)
+
+	results, err := provider.ValidateManifests(context.Background(), manifests)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	exitCode := 0
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			fmt.Fprintf(stdout, "OK:    %s\n", result.Subject)
+		case result.Warning:
+			fmt.Fprintf(stdout, "WARN:  %s: %v\n", result.Subject, result.Err)
+		default:
+			fmt.Fprintf(stdout, "ERROR: %s: %v\n", result.Subject, result.Err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/webhook"
+)
+
+// runWebhook serves the admission webhook until it fails or the process is
+// signalled to stop, returning the process exit code.
+func runWebhook(server *webhook.Server) int {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		log.Error(logmessages.CKCP078, err)
+		return 1
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		log.Error(logmessages.CKCP079, err)
+		return 1
+	}
+	return 0
+}
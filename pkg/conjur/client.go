@@ -1,58 +1,174 @@
 package conjur
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cyberark/conjur-api-go/conjurapi"
-	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ClientFactory returns an implementation of the Client interface given the
 // proper configuration values.
-type ClientFactory func(baseURL, authnID, account, identity, sslCert string) Client
+type ClientFactory func(baseURL, authnID, account, identity, sslCert string, retry RetryPolicy) Client
 
 // Client is an interface to functions required by our CSI Provider.
 type Client interface {
-	GetSecrets(jwt string, secretIds []string) (map[string][]byte, error)
+	GetSecrets(ctx context.Context, jwt string, secretIds []string, currentVersions map[string]string) (map[string]Secret, error)
 }
 
 // ConjurClient interface for the methods we use from conjurapi.Client
 type ConjurClient interface {
 	RetrieveBatchSecretsSafe([]string) (map[string][]byte, error)
+	Resources(*conjurapi.ResourceFilter) ([]map[string]interface{}, error)
+}
+
+// policyBranchSuffix marks a secret ID as referring to every variable visible
+// under a policy branch, rather than to a single variable.
+const policyBranchSuffix = "/*"
+
+// resourcePageSize is the number of resources requested at a time when
+// listing the variables under a policy branch.
+const resourcePageSize = 100
+
+// PolicyBranch reports whether a secret ID refers to all variables under a
+// policy branch, such as "db-credentials/*", and returns the prefix shared by
+// the IDs of those variables, such as "db-credentials/".
+func PolicyBranch(secretID string) (string, bool) {
+	if secretID == "*" {
+		return "", true
+	}
+	if strings.HasSuffix(secretID, policyBranchSuffix) {
+		return strings.TrimSuffix(secretID, "*"), true
+	}
+	return "", false
+}
+
+// Secret holds the value of a Conjur variable along with an identifier for
+// its current version.
+type Secret struct {
+	Value []byte
+	// Version is the variable's current version number in Conjur, or a hash
+	// of Value when the version number cannot be determined.
+	Version string
 }
 
 // Config holds the configuration needed to communicate with Conjur and
 // implements the Client interface.
 type Config struct {
+	// BaseURL is the URL of a Conjur appliance, or a comma or whitespace
+	// separated list of URLs of equivalent appliances, such as followers in
+	// different availability zones.
 	BaseURL       string
 	AuthnID       string
 	Account       string
 	Identity      string
 	SSLCert       string
-	clientFactory func(conjurapi.Config) (ConjurClient, error)
+	Retry         RetryPolicy
+	clientFactory func(context.Context, conjurapi.Config) (ConjurClient, error)
+	tokenCache    *TokenCache
+	endpoints     *endpointCache
+	appliances    *applianceMonitor
+	after         func(time.Duration) <-chan time.Time
 }
 
 // NewClient returns a new Conjur client.
-func NewClient(baseURL, authnID, account, identity, sslCert string) Client {
+func NewClient(baseURL, authnID, account, identity, sslCert string, retry RetryPolicy) Client {
 	return &Config{
 		BaseURL:       baseURL,
 		AuthnID:       authnID,
 		Account:       account,
 		Identity:      identity,
 		SSLCert:       sslCert,
-		clientFactory: defaultClientFactory,
+		Retry:         retry,
+		clientFactory: cachingClientFactory(defaultTokenCache),
+		tokenCache:    defaultTokenCache,
+		endpoints:     defaultEndpointCache,
+		appliances:    defaultApplianceMonitor,
 	}
 }
 
-func defaultClientFactory(config conjurapi.Config) (ConjurClient, error) {
-	return conjurapi.NewClientFromJwt(config)
+// cachingClientFactory returns a factory for Conjur clients which reuse
+// access tokens from the given cache, and only authenticate with the JWT when
+// no valid token is cached.
+func cachingClientFactory(cache *TokenCache) func(context.Context, conjurapi.Config) (ConjurClient, error) {
+	return func(ctx context.Context, config conjurapi.Config) (ConjurClient, error) {
+		client, err := conjurapi.NewClientFromJwt(config)
+		if err != nil {
+			return nil, err
+		}
+
+		client.SetAuthenticator(&cachingAuthenticator{
+			ctx:           ctx,
+			key:           newTokenCacheKey(config),
+			cache:         cache,
+			authenticator: client.GetAuthenticator(),
+		})
+		return client, nil
+	}
 }
 
 // GetSecrets authenticates with Conjur using the provided JWT and returns
-// requested secret data.
-func (c *Config) GetSecrets(jwt string, secretIds []string) (map[string][]byte, error) {
+// requested secret data and versions. Secret IDs referring to a policy branch
+// are replaced by the IDs of every variable visible under that branch. If the
+// versions in Conjur match currentVersions for every requested secret, secret
+// values are not retrieved and the returned Secrets only carry their versions.
+// Requests failing with transient errors are retried according to c.Retry, and
+// failed requests are reported as a *RequestError.
+//
+// When c.BaseURL lists several appliance URLs, they are tried in turn until
+// one serves the request, starting with the last one to succeed. Failing over
+// only happens on transient errors, since a follower rejecting the workload's
+// identity or permissions would be rejected by every other follower too.
+func (c *Config) GetSecrets(ctx context.Context, jwt string, secretIds []string, currentVersions map[string]string) (map[string]Secret, error) {
+	key := endpointKey{
+		applianceURLs: c.BaseURL,
+		account:       c.Account,
+		authnID:       c.AuthnID,
+	}
+	applianceURLs := c.endpoints.order(key, ApplianceURLs(c.BaseURL))
+	if len(applianceURLs) == 0 {
+		// Leave it to config validation to report the missing URL
+		applianceURLs = []string{""}
+	}
+
+	logger := logging.FromContext(ctx)
+	var err error
+	for i, applianceURL := range applianceURLs {
+		var secrets map[string]Secret
+		// Fail over quickly from an unreachable appliance while others remain
+		failover := i < len(applianceURLs)-1
+		secrets, err = c.getSecretsFrom(ctx, applianceURL, failover, jwt, secretIds, currentVersions)
+		if err == nil {
+			c.endpoints.succeeded(key, applianceURL)
+			c.appliances.used(applianceURL, c.SSLCert)
+			return secrets, nil
+		}
+		if !IsRetryable(err) {
+			break
+		}
+		if i < len(applianceURLs)-1 {
+			logger.Warn(logmessages.CKCP054, applianceURL, applianceURLs[i+1], err)
+		}
+	}
+	return nil, err
+}
+
+// getSecretsFrom retrieves secrets from a single Conjur appliance. When
+// failover is set, requests which fail to reach it aren't retried.
+func (c *Config) getSecretsFrom(ctx context.Context, applianceURL string, failover bool, jwt string, secretIds []string, currentVersions map[string]string) (map[string]Secret, error) {
+	logger := logging.FromContext(ctx)
 	serviceID := c.AuthnID
 	if strings.Contains(c.AuthnID, "authn-jwt/") {
 		serviceID = strings.Split(c.AuthnID, "authn-jwt/")[1]
@@ -60,7 +176,7 @@ func (c *Config) GetSecrets(jwt string, secretIds []string) (map[string][]byte,
 
 	config := conjurapi.Config{
 		Account:      c.Account,
-		ApplianceURL: c.BaseURL,
+		ApplianceURL: applianceURL,
 		SSLCert:      c.SSLCert,
 		AuthnType:    "jwt",
 		ServiceID:    serviceID,
@@ -69,26 +185,223 @@ func (c *Config) GetSecrets(jwt string, secretIds []string) (map[string][]byte,
 	}
 
 	if err := config.Validate(); err != nil {
-		log.Error(logmessages.CKCP030, err)
-		return nil, fmt.Errorf(logmessages.CKCP030, err)
+		logger.Error(logmessages.CKCP030, err)
+		return nil, logmessages.Errorf(logmessages.CKCP030, err)
+	}
+
+	authenticatedClient, err := c.clientFactory(ctx, config)
+	if err != nil {
+		logger.Error(logmessages.CKCP030, err)
+		return nil, logmessages.Errorf(logmessages.CKCP030, err)
 	}
 
-	authenticatedClient, err := c.clientFactory(config)
+	// A single listing of the visible variables gives both the variables
+	// under any policy branches and the current version of each variable,
+	// rather than looking each one up in turn.
+	var versions map[string]string
+	err = c.withRetry(ctx, failover, func() error {
+		var err error
+		versions, err = c.listVariables(authenticatedClient, secretIds)
+		return err
+	})
 	if err != nil {
-		log.Error(logmessages.CKCP030, err)
-		return nil, fmt.Errorf(logmessages.CKCP030, err)
+		c.evictToken(config)
+		logger.Error(logmessages.CKCP092, err)
+		return nil, newRequestError(fmt.Sprintf(logmessages.CKCP092, err), err)
 	}
 
-	secretValuesByID := map[string][]byte{}
-	secretValuesByFullID, err := authenticatedClient.RetrieveBatchSecretsSafe(secretIds)
+	secretIds, err = expandPolicyBranches(logger, versions, secretIds)
 	if err != nil {
-		log.Error(logmessages.CKCP031, err)
-		return nil, fmt.Errorf(logmessages.CKCP031, err)
+		return nil, err
 	}
 
 	prefix := fmt.Sprintf("%s:variable:", c.Account)
+	versionsByID := map[string]string{}
+	unchanged := len(secretIds) > 0 && len(secretIds) == len(currentVersions)
+	for _, id := range secretIds {
+		version := versions[id]
+		if version != "" {
+			versionsByID[id] = version
+		} else if _, ok := versions[id]; ok {
+			logger.Debug(logmessages.CKCP043, prefix+id, "no versions listed")
+		} else {
+			logger.Debug(logmessages.CKCP043, prefix+id, "variable not listed")
+		}
+		if version == "" || version != currentVersions[id] {
+			unchanged = false
+		}
+	}
+
+	// Skip retrieving secret values when Conjur reports that none of them
+	// have changed since they were last mounted.
+	if unchanged {
+		logger.Info(logmessages.CKCP044)
+		secretsByID := map[string]Secret{}
+		for id, version := range versionsByID {
+			secretsByID[id] = Secret{Version: version}
+		}
+		return secretsByID, nil
+	}
+
+	var secretValuesByFullID map[string][]byte
+	_, span := tracing.Tracer().Start(ctx, "conjur.RetrieveBatchSecrets", trace.WithAttributes(
+		attribute.String("conjur.appliance_url", applianceURL),
+		attribute.Int("conjur.secret_count", len(secretIds)),
+	))
+	err = c.withRetry(ctx, failover, func() error {
+		start := time.Now()
+		secretValuesByFullID, err = authenticatedClient.RetrieveBatchSecretsSafe(secretIds)
+		metrics.ConjurBatchRetrievalDuration.WithLabelValues(metrics.Result(err)).Observe(time.Since(start).Seconds())
+		return err
+	})
+	tracing.End(span, err)
+	if err != nil {
+		c.evictToken(config)
+		logger.Error(logmessages.CKCP031, err)
+		return nil, newRequestError(fmt.Sprintf(logmessages.CKCP031, err), err)
+	}
+
+	secretsByID := map[string]Secret{}
 	for k, v := range secretValuesByFullID {
-		secretValuesByID[strings.TrimPrefix(k, prefix)] = v
+		id := strings.TrimPrefix(k, prefix)
+		version, ok := versionsByID[id]
+		if !ok {
+			version = contentHash(v)
+		}
+		secretsByID[id] = Secret{
+			Value:   v,
+			Version: version,
+		}
+	}
+	return secretsByID, nil
+}
+
+// evictToken removes any cached access token for the given configuration, so
+// that the next request authenticates again rather than reusing a token that
+// may have been rejected.
+func (c *Config) evictToken(config conjurapi.Config) {
+	if c.tokenCache != nil {
+		c.tokenCache.delete(newTokenCacheKey(config))
+	}
+}
+
+// expandPolicyBranches replaces secret IDs referring to policy branches with
+// the IDs of the visible variables under them, given the variables listed by
+// listVariables.
+func expandPolicyBranches(logger *logging.Logger, variables map[string]string, secretIds []string) ([]string, error) {
+	seen := map[string]bool{}
+	expanded := []string{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			expanded = append(expanded, id)
+		}
 	}
-	return secretValuesByID, nil
+
+	for _, id := range secretIds {
+		branch, ok := PolicyBranch(id)
+		if !ok {
+			add(id)
+			continue
+		}
+
+		matches := []string{}
+		for variable := range variables {
+			if strings.HasPrefix(variable, branch) {
+				matches = append(matches, variable)
+			}
+		}
+		if len(matches) == 0 {
+			logger.Error(logmessages.CKCP050, id)
+			return nil, logmessages.Errorf(logmessages.CKCP050, id)
+		}
+		sort.Strings(matches)
+		for _, v := range matches {
+			add(v)
+		}
+	}
+
+	return expanded, nil
+}
+
+// listVariables lists the variables visible to the authenticated identity
+// which are either among secretIds or under one of their policy branches,
+// returning the latest version of each by ID, or an empty string for those
+// listing no versions. Variables which aren't visible aren't returned, so
+// their versions are left to be determined from their content. Listing ends
+// early once every requested variable has been seen, unless a policy branch
+// requires every page.
+func (c *Config) listVariables(client ConjurClient, secretIds []string) (map[string]string, error) {
+	variablePrefix := fmt.Sprintf("%s:variable:", c.Account)
+	remaining := map[string]bool{}
+	branches := []string{}
+	for _, id := range secretIds {
+		if branch, ok := PolicyBranch(id); ok {
+			branches = append(branches, branch)
+		} else {
+			remaining[id] = true
+		}
+	}
+	wanted := func(id string) bool {
+		if remaining[id] {
+			return true
+		}
+		for _, branch := range branches {
+			if strings.HasPrefix(id, branch) {
+				return true
+			}
+		}
+		return false
+	}
+
+	versions := map[string]string{}
+	for offset := 0; len(remaining) > 0 || len(branches) > 0; offset += resourcePageSize {
+		resources, err := client.Resources(&conjurapi.ResourceFilter{
+			Kind:   "variable",
+			Limit:  resourcePageSize,
+			Offset: offset,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range resources {
+			fullID, _ := resource["id"].(string)
+			id, ok := strings.CutPrefix(fullID, variablePrefix)
+			if ok && wanted(id) {
+				versions[id] = latestVersion(resource)
+				delete(remaining, id)
+			}
+		}
+
+		if len(resources) < resourcePageSize {
+			break
+		}
+	}
+	return versions, nil
+}
+
+// latestVersion returns the latest version number listed by a Conjur
+// variable resource, or an empty string if it lists none.
+func latestVersion(resource map[string]interface{}) string {
+	secrets, _ := resource["secrets"].([]interface{})
+	latest := 0
+	for _, s := range secrets {
+		secret, _ := s.(map[string]interface{})
+		// JSON numbers are decoded as float64
+		if version, ok := secret["version"].(float64); ok && int(version) > latest {
+			latest = int(version)
+		}
+	}
+	if latest == 0 {
+		return ""
+	}
+	return strconv.Itoa(latest)
+}
+
+// contentHash is used in place of a secret's version when the version number
+// can't be determined, so that changes to the value are still detectable.
+func contentHash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return "sha256:" + hex.EncodeToString(sum[:])
 }
@@ -1,25 +1,36 @@
 package conjur
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/cyberark/conjur-api-go/conjurapi"
+	"github.com/cyberark/conjur-api-go/conjurapi/response"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
 )
 
 type mockConjurClient struct {
 	retrieveBatchSecretsSafeFunc func([]string) (map[string][]byte, error)
+	resourcesFunc                func(*conjurapi.ResourceFilter) ([]map[string]interface{}, error)
 }
 
 func (m *mockConjurClient) RetrieveBatchSecretsSafe(ids []string) (map[string][]byte, error) {
 	return m.retrieveBatchSecretsSafeFunc(ids)
 }
 
+func (m *mockConjurClient) Resources(filter *conjurapi.ResourceFilter) ([]map[string]interface{}, error) {
+	if m.resourcesFunc == nil {
+		return []map[string]interface{}{}, nil
+	}
+	return m.resourcesFunc(filter)
+}
+
 func TestNewClient(t *testing.T) {
-	client := NewClient("url", "authn", "account", "identity", "cert")
+	client := NewClient("url", "authn", "account", "identity", "cert", DefaultRetryPolicy)
 	config, ok := client.(*Config)
 	if !ok {
 		t.Fatalf("NewClient did not return a *Config")
@@ -39,10 +50,18 @@ func TestGetSecrets(t *testing.T) {
 		config         Config
 		jwt            string
 		secretIDs      []string
+		currentVersion map[string]string
 		mockSecrets    map[string][]byte
-		mockError      error
-		expectedResult map[string][]byte
-		expectedError  string
+		// mockResources gives the versions of the listed variables, which
+		// are listed after those of mockVariables
+		mockResources map[string]map[string]interface{}
+		mockVariables []string
+		mockError     error
+		// mockListError is returned by every request listing variables
+		mockListError    error
+		expectedResult   map[string]Secret
+		expectedError    string
+		expectedRequests int
 	}{
 		{
 			name: "Successful retrieval",
@@ -59,9 +78,22 @@ func TestGetSecrets(t *testing.T) {
 				"default:variable:secret1": []byte("value1"),
 				"default:variable:secret2": []byte("value2"),
 			},
-			expectedResult: map[string][]byte{
-				"secret1": []byte("value1"),
-				"secret2": []byte("value2"),
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret1": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(1)},
+						map[string]interface{}{"version": float64(2)},
+					},
+				},
+				"default:variable:secret2": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(7)},
+					},
+				},
+			},
+			expectedResult: map[string]Secret{
+				"secret1": {Value: []byte("value1"), Version: "2"},
+				"secret2": {Value: []byte("value2"), Version: "7"},
 			},
 		},
 		{
@@ -116,7 +148,7 @@ func TestGetSecrets(t *testing.T) {
 			},
 			jwt:            "jwt-token",
 			secretIDs:      []string{},
-			expectedResult: map[string][]byte{},
+			expectedResult: map[string]Secret{},
 		},
 		{
 			name: "Different AuthnID format",
@@ -132,30 +164,297 @@ func TestGetSecrets(t *testing.T) {
 			mockSecrets: map[string][]byte{
 				"default:variable:secret1": []byte("value1"),
 			},
-			expectedResult: map[string][]byte{
-				"secret1": []byte("value1"),
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret1": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(1)},
+					},
+				},
+			},
+			expectedResult: map[string]Secret{
+				"secret1": {Value: []byte("value1"), Version: "1"},
+			},
+		},
+		{
+			name: "Version unavailable falls back to content hash",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:       "jwt-token",
+			secretIDs: []string{"secret1", "secret2"},
+			mockSecrets: map[string][]byte{
+				"default:variable:secret1": []byte("value1"),
+				"default:variable:secret2": []byte("value2"),
+			},
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret2": {
+					"secrets": []interface{}{},
+				},
+			},
+			expectedResult: map[string]Secret{
+				"secret1": {
+					Value:   []byte("value1"),
+					Version: "sha256:3c9683017f9e4bf33d0fbedd26bf143fd72de9b9dd145441b75f0604047ea28e",
+				},
+				"secret2": {
+					Value:   []byte("value2"),
+					Version: "sha256:0537d481f73a757334328052da3af9626ced97028e20b849f6115c22cd765197",
+				},
+			},
+		},
+		{
+			name: "Unchanged versions skip retrieval",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:            "jwt-token",
+			secretIDs:      []string{"secret1"},
+			currentVersion: map[string]string{"secret1": "4"},
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret1": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(4)},
+					},
+				},
+			},
+			mockError: fmt.Errorf("batch retrieval should be skipped"),
+			expectedResult: map[string]Secret{
+				"secret1": {Version: "4"},
+			},
+		},
+		{
+			name: "Changed versions are retrieved",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:            "jwt-token",
+			secretIDs:      []string{"secret1", "secret2"},
+			currentVersion: map[string]string{"secret1": "4", "secret2": "1"},
+			mockSecrets: map[string][]byte{
+				"default:variable:secret1": []byte("value1"),
+				"default:variable:secret2": []byte("value2"),
+			},
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret1": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(4)},
+					},
+				},
+				"default:variable:secret2": {
+					"secrets": []interface{}{
+						map[string]interface{}{"version": float64(2)},
+					},
+				},
+			},
+			expectedResult: map[string]Secret{
+				"secret1": {Value: []byte("value1"), Version: "4"},
+				"secret2": {Value: []byte("value2"), Version: "2"},
+			},
+		},
+		{
+			name: "Hidden variable falls back to content hash",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:           "jwt-token",
+			secretIDs:     []string{"secret1"},
+			mockSecrets:   map[string][]byte{"default:variable:secret1": []byte("value1")},
+			mockVariables: []string{"default:variable:other"},
+			expectedResult: map[string]Secret{
+				"secret1": {
+					Value:   []byte("value1"),
+					Version: "sha256:3c9683017f9e4bf33d0fbedd26bf143fd72de9b9dd145441b75f0604047ea28e",
+				},
+			},
+		},
+		{
+			name: "Versions listed with a single request",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:       "jwt-token",
+			secretIDs: []string{"secret1", "secret2", "secret3"},
+			mockVariables: func() []string {
+				// Listing stops once every variable has been seen
+				ids := []string{"default:variable:secret1", "default:variable:secret2", "default:variable:secret3"}
+				for i := 0; i < 2*resourcePageSize; i++ {
+					ids = append(ids, fmt.Sprintf("default:variable:unrelated/%d", i))
+				}
+				return ids
+			}(),
+			mockSecrets: map[string][]byte{
+				"default:variable:secret1": []byte("value1"),
+				"default:variable:secret2": []byte("value2"),
+				"default:variable:secret3": []byte("value3"),
+			},
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:secret1": {"secrets": []interface{}{map[string]interface{}{"version": float64(1)}}},
+				"default:variable:secret2": {"secrets": []interface{}{map[string]interface{}{"version": float64(2)}}},
+				"default:variable:secret3": {"secrets": []interface{}{map[string]interface{}{"version": float64(3)}}},
+			},
+			expectedResult: map[string]Secret{
+				"secret1": {Value: []byte("value1"), Version: "1"},
+				"secret2": {Value: []byte("value2"), Version: "2"},
+				"secret3": {Value: []byte("value3"), Version: "3"},
 			},
+			expectedRequests: 2,
+		},
+		{
+			name: "Variable listing authentication error",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:              "jwt-token",
+			secretIDs:        []string{"secret1", "secret2", "secret3"},
+			mockSecrets:      map[string][]byte{"default:variable:secret1": []byte("value1")},
+			mockListError:    &response.ConjurError{Code: 401, Message: "Unauthorized"},
+			expectedError:    fmt.Sprintf(logmessages.CKCP092, "Unauthorized. "),
+			expectedRequests: 1,
+		},
+		{
+			name: "Policy branch expanded",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:       "jwt-token",
+			secretIDs: []string{"db/*", "other"},
+			mockVariables: func() []string {
+				// Span multiple pages of results
+				ids := []string{"default:variable:db/url", "default:variable:db/nested/password"}
+				for i := 0; i < resourcePageSize; i++ {
+					ids = append(ids, fmt.Sprintf("default:variable:unrelated/%d", i))
+				}
+				return append(ids, "default:variable:db/username", "default:variable:dbx/password")
+			}(),
+			mockSecrets: map[string][]byte{
+				"default:variable:db/url":             []byte("url"),
+				"default:variable:db/nested/password": []byte("password"),
+				"default:variable:db/username":        []byte("username"),
+				"default:variable:other":              []byte("other"),
+			},
+			mockResources: map[string]map[string]interface{}{
+				"default:variable:db/url": {
+					"secrets": []interface{}{map[string]interface{}{"version": float64(1)}},
+				},
+				"default:variable:db/nested/password": {
+					"secrets": []interface{}{map[string]interface{}{"version": float64(1)}},
+				},
+				"default:variable:db/username": {
+					"secrets": []interface{}{map[string]interface{}{"version": float64(1)}},
+				},
+				"default:variable:other": {
+					"secrets": []interface{}{map[string]interface{}{"version": float64(1)}},
+				},
+			},
+			expectedResult: map[string]Secret{
+				"db/url":             {Value: []byte("url"), Version: "1"},
+				"db/nested/password": {Value: []byte("password"), Version: "1"},
+				"db/username":        {Value: []byte("username"), Version: "1"},
+				"other":              {Value: []byte("other"), Version: "1"},
+			},
+		},
+		{
+			name: "Policy branch without variables",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:           "jwt-token",
+			secretIDs:     []string{"db/*"},
+			mockVariables: []string{"default:variable:other"},
+			expectedError: fmt.Sprintf(logmessages.CKCP050, "db/*"),
+		},
+		{
+			name: "Policy branch listing error",
+			config: Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+			},
+			jwt:           "jwt-token",
+			secretIDs:     []string{"db/*"},
+			mockListError: fmt.Errorf("listing error"),
+			expectedError: fmt.Sprintf(logmessages.CKCP092, "listing error"),
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			tc.config.clientFactory = func(config conjurapi.Config) (ConjurClient, error) {
+			// Variables with versions are listed after any others
+			listed := append([]string{}, tc.mockVariables...)
+			for id := range tc.mockResources {
+				if !slices.Contains(listed, id) {
+					listed = append(listed, id)
+				}
+			}
+			slices.Sort(listed[len(tc.mockVariables):])
+
+			requests := 0
+			tc.config.clientFactory = func(ctx context.Context, config conjurapi.Config) (ConjurClient, error) {
 				if tc.name == "Client factory error" {
 					return nil, tc.mockError
 				}
 				mockClient := &mockConjurClient{
 					retrieveBatchSecretsSafeFunc: func(ids []string) (map[string][]byte, error) {
-						if tc.name == "Retrieve error" {
+						requests++
+						if tc.name == "Retrieve error" || tc.name == "Unchanged versions skip retrieval" {
 							return nil, tc.mockError
 						}
 						return tc.mockSecrets, nil
 					},
+					resourcesFunc: func(filter *conjurapi.ResourceFilter) ([]map[string]interface{}, error) {
+						requests++
+						if tc.mockListError != nil {
+							return nil, tc.mockListError
+						}
+						page := []map[string]interface{}{}
+						for i := filter.Offset; i < len(listed) && i < filter.Offset+filter.Limit; i++ {
+							resource := map[string]interface{}{"id": listed[i]}
+							if secrets, ok := tc.mockResources[listed[i]]["secrets"]; ok {
+								resource["secrets"] = secrets
+							}
+							page = append(page, resource)
+						}
+						return page, nil
+					},
 				}
 				return mockClient, nil
 			}
 
-			result, err := tc.config.GetSecrets(tc.jwt, tc.secretIDs)
+			result, err := tc.config.GetSecrets(context.Background(), tc.jwt, tc.secretIDs, tc.currentVersion)
 
 			if tc.expectedError != "" {
 				if err == nil {
@@ -170,6 +469,32 @@ func TestGetSecrets(t *testing.T) {
 			if !reflect.DeepEqual(result, tc.expectedResult) {
 				t.Errorf("Expected result %v, got %v", tc.expectedResult, result)
 			}
+			if tc.expectedRequests > 0 && requests != tc.expectedRequests {
+				t.Errorf("Expected %d requests to Conjur, got %d", tc.expectedRequests, requests)
+			}
+		})
+	}
+}
+
+func TestPolicyBranch(t *testing.T) {
+	testCases := []struct {
+		secretID       string
+		expectedPrefix string
+		expectedOk     bool
+	}{
+		{secretID: "db-credentials/*", expectedPrefix: "db-credentials/", expectedOk: true},
+		{secretID: "apps/db/*", expectedPrefix: "apps/db/", expectedOk: true},
+		{secretID: "*", expectedPrefix: "", expectedOk: true},
+		{secretID: "db-credentials/url", expectedPrefix: "", expectedOk: false},
+		{secretID: "db-credentials*", expectedPrefix: "", expectedOk: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.secretID, func(t *testing.T) {
+			prefix, ok := PolicyBranch(tc.secretID)
+			if prefix != tc.expectedPrefix || ok != tc.expectedOk {
+				t.Errorf("Expected (%q, %v), got (%q, %v)", tc.expectedPrefix, tc.expectedOk, prefix, ok)
+			}
 		})
 	}
 }
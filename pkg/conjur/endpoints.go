@@ -0,0 +1,73 @@
+package conjur
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// defaultEndpointCache is shared by all clients created by NewClient, so that
+// mounts using the same configuration go straight to the appliance which last
+// served them.
+var defaultEndpointCache = newEndpointCache()
+
+// ApplianceURLs splits a list of Conjur appliance URLs separated by commas or
+// whitespace, as accepted by the applianceUrl SecretProviderClass parameter.
+func ApplianceURLs(baseURL string) []string {
+	return strings.FieldsFunc(baseURL, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// endpointKey identifies a configuration sharing a list of appliance URLs.
+type endpointKey struct {
+	applianceURLs string
+	account       string
+	authnID       string
+}
+
+// endpointCache remembers the last appliance URL which successfully served
+// requests for each configuration.
+type endpointCache struct {
+	mutex    sync.Mutex
+	lastGood map[endpointKey]string
+}
+
+func newEndpointCache() *endpointCache {
+	return &endpointCache{lastGood: map[endpointKey]string{}}
+}
+
+// order returns the appliance URLs in the order they should be tried: the
+// last one to succeed for the configuration first, followed by the rest in
+// their declared order.
+func (c *endpointCache) order(key endpointKey, applianceURLs []string) []string {
+	if c == nil {
+		return applianceURLs
+	}
+
+	c.mutex.Lock()
+	lastGood, ok := c.lastGood[key]
+	c.mutex.Unlock()
+	if !ok {
+		return applianceURLs
+	}
+
+	ordered := []string{lastGood}
+	for _, applianceURL := range applianceURLs {
+		if applianceURL != lastGood {
+			ordered = append(ordered, applianceURL)
+		}
+	}
+	return ordered
+}
+
+// succeeded records the appliance URL which served a request.
+func (c *endpointCache) succeeded(key endpointKey, applianceURL string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastGood[key] = applianceURL
+}
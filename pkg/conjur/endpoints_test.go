@@ -0,0 +1,198 @@
+package conjur
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-api-go/conjurapi"
+	"github.com/cyberark/conjur-api-go/conjurapi/response"
+)
+
+func TestApplianceURLs(t *testing.T) {
+	testCases := []struct {
+		baseURL  string
+		expected []string
+	}{
+		{baseURL: "https://conjur", expected: []string{"https://conjur"}},
+		{baseURL: "https://a,https://b", expected: []string{"https://a", "https://b"}},
+		{baseURL: "https://a, https://b,\n https://c\n", expected: []string{"https://a", "https://b", "https://c"}},
+		{baseURL: "", expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.baseURL, func(t *testing.T) {
+			if actual := ApplianceURLs(tc.baseURL); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGetSecretsFailover(t *testing.T) {
+	unavailable := &response.ConjurError{Code: 503, Message: "Service Unavailable"}
+	forbidden := &response.ConjurError{Code: 403, Message: "Forbidden"}
+	refused := fmt.Errorf("dial tcp: %w", syscall.ECONNREFUSED)
+	retry := RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	testCases := []struct {
+		name             string
+		retry            RetryPolicy
+		errors           map[string]error
+		listErrors       map[string]error
+		lastGood         string
+		expectedRequests []string
+		expectedLastGood string
+		expectedError    bool
+	}{
+		{
+			name:             "Uses first appliance when available",
+			expectedRequests: []string{"https://a"},
+			expectedLastGood: "https://a",
+		},
+		{
+			name:             "Fails over on transient errors",
+			errors:           map[string]error{"https://a": unavailable},
+			expectedRequests: []string{"https://a", "https://b"},
+			expectedLastGood: "https://b",
+		},
+		{
+			name:             "Starts with last good appliance",
+			lastGood:         "https://b",
+			expectedRequests: []string{"https://b"},
+			expectedLastGood: "https://b",
+		},
+		{
+			name:             "Falls back to other appliances after last good fails",
+			errors:           map[string]error{"https://b": unavailable},
+			lastGood:         "https://b",
+			expectedRequests: []string{"https://b", "https://a"},
+			expectedLastGood: "https://a",
+		},
+		{
+			name:             "Fails over when listing variables fails",
+			listErrors:       map[string]error{"https://a": unavailable},
+			expectedRequests: []string{"https://b"},
+			expectedLastGood: "https://b",
+		},
+		{
+			name:             "Retries error responses before failing over",
+			retry:            retry,
+			errors:           map[string]error{"https://a": unavailable},
+			expectedRequests: []string{"https://a", "https://a", "https://a", "https://b"},
+			expectedLastGood: "https://b",
+		},
+		{
+			name:             "Fails over on connection errors without retrying",
+			retry:            retry,
+			errors:           map[string]error{"https://a": refused},
+			expectedRequests: []string{"https://a", "https://b"},
+			expectedLastGood: "https://b",
+		},
+		{
+			name:             "Retries connection errors to the last appliance",
+			retry:            retry,
+			errors:           map[string]error{"https://a": refused, "https://b": refused},
+			expectedRequests: []string{"https://a", "https://b", "https://b", "https://b"},
+			expectedError:    true,
+		},
+		{
+			name:             "Doesn't fail over on permanent errors",
+			errors:           map[string]error{"https://a": forbidden},
+			expectedRequests: []string{"https://a"},
+			expectedError:    true,
+		},
+		{
+			name:             "Fails when every appliance is unavailable",
+			errors:           map[string]error{"https://a": unavailable, "https://b": unavailable},
+			expectedRequests: []string{"https://a", "https://b"},
+			expectedError:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := []string{}
+			config := Config{
+				BaseURL:    "https://a, https://b",
+				AuthnID:    "authn-jwt/kube",
+				Account:    "default",
+				Identity:   "host/test",
+				SSLCert:    "cert",
+				Retry:      tc.retry,
+				endpoints:  newEndpointCache(),
+				appliances: newApplianceMonitor(),
+				after: func(time.Duration) <-chan time.Time {
+					return time.After(0)
+				},
+				clientFactory: func(ctx context.Context, config conjurapi.Config) (ConjurClient, error) {
+					return &mockConjurClient{
+						retrieveBatchSecretsSafeFunc: func(ids []string) (map[string][]byte, error) {
+							requests = append(requests, config.ApplianceURL)
+							if err := tc.errors[config.ApplianceURL]; err != nil {
+								return nil, err
+							}
+							return map[string][]byte{"default:variable:secret": []byte("value")}, nil
+						},
+						resourcesFunc: func(*conjurapi.ResourceFilter) ([]map[string]interface{}, error) {
+							if err := tc.listErrors[config.ApplianceURL]; err != nil {
+								return nil, err
+							}
+							return []map[string]interface{}{}, nil
+						},
+					}, nil
+				},
+			}
+			key := endpointKey{applianceURLs: config.BaseURL, account: config.Account, authnID: config.AuthnID}
+			if tc.lastGood != "" {
+				config.endpoints.succeeded(key, tc.lastGood)
+			}
+
+			_, err := config.GetSecrets(context.Background(), "jwt-token", []string{"secret"}, nil)
+
+			if tc.expectedError != (err != nil) {
+				t.Errorf("Expected error: %v, got %v", tc.expectedError, err)
+			}
+			if !reflect.DeepEqual(requests, tc.expectedRequests) {
+				t.Errorf("Expected requests to %q, got %q", tc.expectedRequests, requests)
+			}
+			if tc.expectedLastGood != "" {
+				if actual := config.endpoints.lastGood[key]; actual != tc.expectedLastGood {
+					t.Errorf("Expected last good appliance %q, got %q", tc.expectedLastGood, actual)
+				}
+			}
+
+			// Only the appliance which served the request is checked for readiness
+			used := []string{}
+			for applianceURL := range config.appliances.appliances {
+				used = append(used, applianceURL)
+			}
+			expectedUsed := []string{}
+			if tc.expectedLastGood != "" {
+				expectedUsed = append(expectedUsed, tc.expectedLastGood)
+			}
+			if !reflect.DeepEqual(used, expectedUsed) {
+				t.Errorf("Expected used appliances %q, got %q", expectedUsed, used)
+			}
+		})
+	}
+}
+
+func TestEndpointCacheIsPerConfiguration(t *testing.T) {
+	cache := newEndpointCache()
+	urls := []string{"https://a", "https://b"}
+	first := endpointKey{applianceURLs: "https://a,https://b", account: "first"}
+	second := endpointKey{applianceURLs: "https://a,https://b", account: "second"}
+
+	cache.succeeded(first, "https://b")
+
+	if actual := cache.order(first, urls); fmt.Sprint(actual) != "[https://b https://a]" {
+		t.Errorf("Expected last good appliance first, got %q", actual)
+	}
+	if actual := cache.order(second, urls); fmt.Sprint(actual) != "[https://a https://b]" {
+		t.Errorf("Expected declared order, got %q", actual)
+	}
+}
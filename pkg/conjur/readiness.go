@@ -0,0 +1,178 @@
+package conjur
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+)
+
+const (
+	// recentApplianceWindow is how long after its last use an appliance URL
+	// continues to be checked for readiness.
+	recentApplianceWindow = 10 * time.Minute
+	// applianceStatusTTL is how long the result of a readiness check is
+	// reused, so that frequent readiness probes don't load Conjur.
+	applianceStatusTTL = 15 * time.Second
+	// applianceCheckTimeout bounds each request made to check an appliance.
+	applianceCheckTimeout = 2 * time.Second
+)
+
+// defaultApplianceMonitor records the appliances used by all clients created
+// by NewClient.
+var defaultApplianceMonitor = newApplianceMonitor()
+
+// ApplianceStatus is the result of checking whether a Conjur appliance is
+// reachable and healthy.
+type ApplianceStatus struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type applianceState struct {
+	sslCert  string
+	lastUsed time.Time
+	status   *ApplianceStatus
+}
+
+// applianceMonitor keeps track of recently used Conjur appliances and checks
+// their health on demand.
+type applianceMonitor struct {
+	mutex      sync.Mutex
+	appliances map[string]*applianceState
+	now        func() time.Time
+	check      func(ctx context.Context, applianceURL, sslCert string) error
+}
+
+func newApplianceMonitor() *applianceMonitor {
+	return &applianceMonitor{
+		appliances: map[string]*applianceState{},
+		now:        time.Now,
+		check:      checkApplianceHealth,
+	}
+}
+
+// used records that an appliance URL was used to serve a request.
+func (m *applianceMonitor) used(applianceURL, sslCert string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	state, ok := m.appliances[applianceURL]
+	if !ok || state.sslCert != sslCert {
+		state = &applianceState{sslCert: sslCert}
+		m.appliances[applianceURL] = state
+	}
+	state.lastUsed = m.now()
+}
+
+// statuses checks every recently used appliance, reusing recent results, and
+// returns their statuses ordered by URL.
+func (m *applianceMonitor) statuses(ctx context.Context) []ApplianceStatus {
+	m.mutex.Lock()
+	now := m.now()
+	stale := map[string]string{}
+	for applianceURL, state := range m.appliances {
+		if now.Sub(state.lastUsed) > recentApplianceWindow {
+			delete(m.appliances, applianceURL)
+			continue
+		}
+		if state.status == nil || now.Sub(state.status.CheckedAt) > applianceStatusTTL {
+			stale[applianceURL] = state.sslCert
+		}
+	}
+	m.mutex.Unlock()
+
+	// Check appliances concurrently so that one unresponsive appliance
+	// doesn't delay the others
+	var wg sync.WaitGroup
+	results := make(chan ApplianceStatus, len(stale))
+	for applianceURL, sslCert := range stale {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, applianceCheckTimeout)
+			defer cancel()
+
+			status := ApplianceStatus{URL: applianceURL, Healthy: true, CheckedAt: now}
+			if err := m.check(checkCtx, applianceURL, sslCert); err != nil {
+				log.Warn(logmessages.CKCP060, applianceURL, err)
+				status.Healthy = false
+				status.Error = err.Error()
+			}
+			results <- status
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for status := range results {
+		if state, ok := m.appliances[status.URL]; ok {
+			state.status = &status
+		}
+	}
+
+	statuses := []ApplianceStatus{}
+	for _, state := range m.appliances {
+		if state.status != nil {
+			statuses = append(statuses, *state.status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URL < statuses[j].URL
+	})
+	return statuses
+}
+
+// CheckAppliances checks the health of every Conjur appliance used to serve a
+// mount request in the last few minutes.
+func CheckAppliances(ctx context.Context) []ApplianceStatus {
+	return defaultApplianceMonitor.statuses(ctx)
+}
+
+// checkApplianceHealth requests the health endpoint of a Conjur appliance.
+// Any response other than a server error shows the appliance is able to
+// serve requests, since the endpoint isn't exposed by every deployment.
+func checkApplianceHealth(ctx context.Context, applianceURL, sslCert string) error {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if sslCert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(sslCert)) {
+			return fmt.Errorf("invalid SSL certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(applianceURL, "/")+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("health endpoint returned %s", resp.Status)
+	}
+	return nil
+}
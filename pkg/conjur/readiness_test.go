@@ -0,0 +1,94 @@
+package conjur
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestApplianceMonitor(t *testing.T) {
+	now := time.Now()
+	monitor := newApplianceMonitor()
+	monitor.now = func() time.Time { return now }
+	var mutex sync.Mutex
+	checks := map[string]int{}
+	monitor.check = func(ctx context.Context, applianceURL, sslCert string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		checks[applianceURL]++
+		if applianceURL == "https://down" {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	if statuses := monitor.statuses(context.Background()); len(statuses) != 0 {
+		t.Fatalf("Expected no statuses before any appliance is used, got %v", statuses)
+	}
+
+	monitor.used("https://up", "cert")
+	monitor.used("https://down", "cert")
+	statuses := monitor.statuses(context.Background())
+	if len(statuses) != 2 ||
+		statuses[0].URL != "https://down" || statuses[0].Healthy || statuses[0].Error != "connection refused" ||
+		statuses[1].URL != "https://up" || !statuses[1].Healthy {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+
+	// Results are reused until they expire
+	monitor.statuses(context.Background())
+	if checks["https://up"] != 1 {
+		t.Errorf("Expected cached status to be reused, got %d checks", checks["https://up"])
+	}
+	now = now.Add(applianceStatusTTL + time.Second)
+	monitor.statuses(context.Background())
+	if checks["https://up"] != 2 {
+		t.Errorf("Expected expired status to be rechecked, got %d checks", checks["https://up"])
+	}
+
+	// Appliances which haven't been used recently are no longer checked
+	monitor.used("https://up", "cert")
+	now = now.Add(recentApplianceWindow - time.Second)
+	monitor.used("https://up", "cert")
+	now = now.Add(2 * time.Second)
+	statuses = monitor.statuses(context.Background())
+	if len(statuses) != 1 || statuses[0].URL != "https://up" {
+		t.Errorf("Expected only recently used appliance, got %+v", statuses)
+	}
+}
+
+func TestCheckApplianceHealth(t *testing.T) {
+	testCases := []struct {
+		name          string
+		statusCode    int
+		expectedError bool
+	}{
+		{name: "Healthy", statusCode: http.StatusOK},
+		{name: "Endpoint not exposed", statusCode: http.StatusNotFound},
+		{name: "Unhealthy", statusCode: http.StatusBadGateway, expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					t.Errorf("Unexpected request to %s", r.URL.Path)
+				}
+				w.WriteHeader(tc.statusCode)
+			}))
+			defer server.Close()
+			sslCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+			err := checkApplianceHealth(context.Background(), server.URL+"/", sslCert)
+
+			if tc.expectedError != (err != nil) {
+				t.Errorf("Expected error: %v, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package conjur
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/cyberark/conjur-api-go/conjurapi/response"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+)
+
+// RetryPolicy controls how requests to Conjur are retried after transient
+// failures. Authentication happens as part of the first request made by a
+// client, so it is retried along with that request.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed request is retried. Zero
+	// disables retries.
+	MaxRetries int
+	// BaseDelay is the delay before the first retry. It doubles after each
+	// further retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is used when a SecretProviderClass doesn't configure
+// retries. It keeps the total time spent retrying well below the CSI driver's
+// mount timeout.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	BaseDelay:  500 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+}
+
+// delay returns the time to wait before the given retry, starting at 1. Half
+// of the exponential delay is randomized so that providers on many nodes
+// don't retry against Conjur in lockstep.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < retry && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// RequestError is returned by GetSecrets when a request to Conjur fails. It
+// records the HTTP status code of Conjur's response, if one was received, and
+// whether the failure was transient.
+type RequestError struct {
+	message string
+	// StatusCode is the HTTP status code returned by Conjur, or 0 if the
+	// request failed without a response.
+	StatusCode int
+	// Retryable reports whether the request failed for a reason expected to
+	// be transient, such as a 5xx response or a connection timeout.
+	Retryable bool
+	// Err is the underlying error returned by the Conjur API client.
+	Err error
+}
+
+func newRequestError(message string, err error) *RequestError {
+	requestErr := &RequestError{
+		message:   message,
+		Retryable: IsRetryable(err),
+		Err:       err,
+	}
+	var conjurErr *response.ConjurError
+	if errors.As(err, &conjurErr) {
+		requestErr.StatusCode = conjurErr.Code
+	}
+	return requestErr
+}
+
+func (e *RequestError) Error() string {
+	return e.message
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorCategory categorizes a failed request by Conjur's response.
+func (e *RequestError) ErrorCategory() logmessages.Category {
+	switch {
+	case e.Retryable:
+		return logmessages.CategoryTransient
+	case e.StatusCode == http.StatusUnauthorized:
+		return logmessages.CategoryAuth
+	case e.StatusCode == http.StatusForbidden:
+		return logmessages.CategoryPermission
+	case e.StatusCode == http.StatusNotFound:
+		return logmessages.CategoryNotFound
+	case e.StatusCode == http.StatusBadRequest || e.StatusCode == http.StatusUnprocessableEntity:
+		return logmessages.CategoryConfig
+	default:
+		return ""
+	}
+}
+
+// IsRetryable reports whether an error returned by the Conjur API client is
+// transient and the request may succeed if retried. Server errors, rate
+// limiting, timeouts and dropped connections are retryable. Any other error,
+// including authentication (401), authorization (403) and not found (404)
+// responses, is permanent.
+func IsRetryable(err error) bool {
+	var requestErr *RequestError
+	if errors.As(err, &requestErr) {
+		return requestErr.Retryable
+	}
+
+	var conjurErr *response.ConjurError
+	if errors.As(err, &conjurErr) {
+		return conjurErr.Code >= http.StatusInternalServerError ||
+			conjurErr.Code == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// isConnectionError reports whether a retryable error was caused by failing to
+// reach Conjur at all, such as a refused connection or a timeout, rather than
+// by a response from Conjur.
+func isConnectionError(err error) bool {
+	var conjurErr *response.ConjurError
+	return IsRetryable(err) && !errors.As(err, &conjurErr)
+}
+
+// withRetry calls request until it succeeds, fails with a permanent error, or
+// the retry policy is exhausted, and returns the last error. It stops waiting
+// and returns ctx's error as soon as ctx is done. When failover is set,
+// connection errors are returned without retrying, so that another appliance
+// can be tried rather than waiting on one which can't be reached.
+func (c *Config) withRetry(ctx context.Context, failover bool, request func() error) error {
+	after := c.after
+	if after == nil {
+		after = time.After
+	}
+
+	for retry := 1; ; retry++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := request()
+		if err == nil || retry > c.Retry.MaxRetries || !IsRetryable(err) {
+			return err
+		}
+		if failover && isConnectionError(err) {
+			return err
+		}
+
+		delay := c.Retry.delay(retry)
+		logging.FromContext(ctx).Warn(logmessages.CKCP052, delay, retry, c.Retry.MaxRetries, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-after(delay):
+		}
+	}
+}
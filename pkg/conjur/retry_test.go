@@ -0,0 +1,249 @@
+package conjur
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-api-go/conjurapi"
+	"github.com/cyberark/conjur-api-go/conjurapi/response"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Bad gateway", err: &response.ConjurError{Code: 502}, expected: true},
+		{name: "Service unavailable", err: &response.ConjurError{Code: 503}, expected: true},
+		{name: "Too many requests", err: &response.ConjurError{Code: 429}, expected: true},
+		{name: "Unauthorized", err: &response.ConjurError{Code: 401}, expected: false},
+		{name: "Forbidden", err: &response.ConjurError{Code: 403}, expected: false},
+		{name: "Not found", err: &response.ConjurError{Code: 404}, expected: false},
+		{name: "Timeout", err: &url.Error{Op: "Post", URL: "https://conjur", Err: timeoutError{}}, expected: true},
+		{name: "Connection reset", err: &url.Error{Op: "Get", URL: "https://conjur", Err: syscall.ECONNRESET}, expected: true},
+		{name: "Connection refused", err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, expected: true},
+		{name: "Wrapped in request error", err: newRequestError("failed", &response.ConjurError{Code: 500}), expected: true},
+		{name: "Other error", err: fmt.Errorf("invalid certificate"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := IsRetryable(tc.err); actual != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRequestErrorCategory(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected logmessages.Category
+	}{
+		{name: "Service unavailable", err: &response.ConjurError{Code: 503}, expected: logmessages.CategoryTransient},
+		{name: "Timeout", err: &url.Error{Op: "Post", URL: "https://conjur", Err: timeoutError{}}, expected: logmessages.CategoryTransient},
+		{name: "Unauthorized", err: &response.ConjurError{Code: 401}, expected: logmessages.CategoryAuth},
+		{name: "Forbidden", err: &response.ConjurError{Code: 403}, expected: logmessages.CategoryPermission},
+		{name: "Not found", err: &response.ConjurError{Code: 404}, expected: logmessages.CategoryNotFound},
+		{name: "Unprocessable entity", err: &response.ConjurError{Code: 422}, expected: logmessages.CategoryConfig},
+		{name: "Other error", err: fmt.Errorf("invalid certificate"), expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := newRequestError("CKCP031 Failed to retrieve batch secrets", tc.err)
+			if actual := logmessages.CategoryOf(err); actual != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	testCases := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 1, max: 100 * time.Millisecond},
+		{retry: 2, max: 200 * time.Millisecond},
+		{retry: 3, max: 400 * time.Millisecond},
+		{retry: 5, max: time.Second},
+		{retry: 50, max: time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("retry %d", tc.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := policy.delay(tc.retry)
+				if delay < tc.max/2 || delay > tc.max {
+					t.Fatalf("Expected delay between %s and %s, got %s", tc.max/2, tc.max, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSecretsRetries(t *testing.T) {
+	badGateway := &response.ConjurError{Code: 502, Message: "Bad Gateway"}
+	forbidden := &response.ConjurError{Code: 403, Message: "Forbidden"}
+
+	testCases := []struct {
+		name               string
+		retry              RetryPolicy
+		errors             []error
+		expectedCalls      int
+		expectedSleeps     int
+		expectedStatusCode int
+		expectedRetryable  bool
+		expectedError      bool
+	}{
+		{
+			name:           "Succeeds after transient errors",
+			retry:          RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			errors:         []error{badGateway, badGateway},
+			expectedCalls:  3,
+			expectedSleeps: 2,
+		},
+		{
+			name:               "Gives up when retries are exhausted",
+			retry:              RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			errors:             []error{badGateway, badGateway, badGateway, badGateway},
+			expectedCalls:      3,
+			expectedSleeps:     2,
+			expectedStatusCode: 502,
+			expectedRetryable:  true,
+			expectedError:      true,
+		},
+		{
+			name:               "Doesn't retry permanent errors",
+			retry:              RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+			errors:             []error{forbidden},
+			expectedCalls:      1,
+			expectedStatusCode: 403,
+			expectedError:      true,
+		},
+		{
+			name:               "Doesn't retry when retries are disabled",
+			errors:             []error{badGateway},
+			expectedCalls:      1,
+			expectedStatusCode: 502,
+			expectedRetryable:  true,
+			expectedError:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			sleeps := 0
+			config := Config{
+				BaseURL:  "https://example.com",
+				AuthnID:  "authn-jwt/kube",
+				Account:  "default",
+				Identity: "host/test",
+				SSLCert:  "cert",
+				Retry:    tc.retry,
+				clientFactory: func(context.Context, conjurapi.Config) (ConjurClient, error) {
+					return &mockConjurClient{
+						retrieveBatchSecretsSafeFunc: func(ids []string) (map[string][]byte, error) {
+							calls++
+							if calls <= len(tc.errors) {
+								return nil, tc.errors[calls-1]
+							}
+							return map[string][]byte{"default:variable:secret": []byte("value")}, nil
+						},
+					}, nil
+				},
+				after: func(time.Duration) <-chan time.Time {
+					sleeps++
+					return time.After(0)
+				},
+			}
+
+			result, err := config.GetSecrets(context.Background(), "jwt-token", []string{"secret"}, nil)
+
+			if calls != tc.expectedCalls {
+				t.Errorf("Expected %d requests, got %d", tc.expectedCalls, calls)
+			}
+			if sleeps != tc.expectedSleeps {
+				t.Errorf("Expected %d sleeps, got %d", tc.expectedSleeps, sleeps)
+			}
+			if !tc.expectedError {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				if string(result["secret"].Value) != "value" {
+					t.Errorf("Expected secret value, got %v", result)
+				}
+				return
+			}
+
+			var requestErr *RequestError
+			if !errors.As(err, &requestErr) {
+				t.Fatalf("Expected *RequestError, got %v", err)
+			}
+			if requestErr.StatusCode != tc.expectedStatusCode {
+				t.Errorf("Expected status code %d, got %d", tc.expectedStatusCode, requestErr.StatusCode)
+			}
+			if requestErr.Retryable != tc.expectedRetryable {
+				t.Errorf("Expected retryable %v, got %v", tc.expectedRetryable, requestErr.Retryable)
+			}
+		})
+	}
+}
+
+func TestGetSecretsRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := 0
+	config := Config{
+		BaseURL:  "https://example.com",
+		AuthnID:  "authn-jwt/kube",
+		Account:  "default",
+		Identity: "host/test",
+		SSLCert:  "cert",
+		Retry:    RetryPolicy{MaxRetries: 3, BaseDelay: time.Hour, MaxDelay: time.Hour},
+		clientFactory: func(context.Context, conjurapi.Config) (ConjurClient, error) {
+			return &mockConjurClient{
+				retrieveBatchSecretsSafeFunc: func(ids []string) (map[string][]byte, error) {
+					calls++
+					return nil, &response.ConjurError{Code: 502, Message: "Bad Gateway"}
+				},
+			}, nil
+		},
+		// The request is cancelled while backing off, before the delay ends
+		after: func(time.Duration) <-chan time.Time {
+			cancel()
+			return nil
+		},
+	}
+
+	_, err := config.GetSecrets(ctx, "jwt-token", []string{"secret"}, nil)
+
+	if calls != 1 {
+		t.Errorf("Expected 1 request, got %d", calls)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
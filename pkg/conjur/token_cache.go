@@ -0,0 +1,147 @@
+package conjur
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/cyberark/conjur-api-go/conjurapi"
+	"github.com/cyberark/conjur-api-go/conjurapi/authn"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/tracing"
+)
+
+// DefaultTokenCacheTTL bounds how long a Conjur access token is reused,
+// regardless of its own expiration. Conjur access tokens are valid for 8
+// minutes by default.
+const DefaultTokenCacheTTL = 4 * time.Minute
+
+// defaultTokenCache is shared by all clients created by NewClient, so that
+// repeated mounts for the same workload reuse its access token.
+var defaultTokenCache = NewTokenCache(DefaultTokenCacheTTL)
+
+// tokenCacheKey identifies the Conjur identity an access token was issued to.
+// The JWT is stored as a hash so that it isn't retained in memory.
+type tokenCacheKey struct {
+	applianceURL string
+	account      string
+	authnID      string
+	identity     string
+	jwtHash      [sha256.Size]byte
+}
+
+func newTokenCacheKey(config conjurapi.Config) tokenCacheKey {
+	return tokenCacheKey{
+		applianceURL: config.ApplianceURL,
+		account:      config.Account,
+		authnID:      config.ServiceID,
+		identity:     config.JWTHostID,
+		jwtHash:      sha256.Sum256([]byte(config.JWTContent)),
+	}
+}
+
+type tokenCacheEntry struct {
+	token     *authn.AuthnToken
+	expiresAt time.Time
+}
+
+// TokenCache is an in-memory cache of Conjur access tokens. Entries are
+// evicted once they are older than the cache's TTL, or once the Conjur API
+// client would consider the token due for refresh, whichever comes first.
+type TokenCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[tokenCacheKey]tokenCacheEntry
+	now     func() time.Time
+}
+
+// NewTokenCache creates an empty TokenCache with the given TTL.
+func NewTokenCache(ttl time.Duration) *TokenCache {
+	return &TokenCache{
+		ttl:     ttl,
+		entries: map[tokenCacheKey]tokenCacheEntry{},
+		now:     time.Now,
+	}
+}
+
+func (c *TokenCache) get(key tokenCacheKey) *authn.AuthnToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !c.now().Before(entry.expiresAt) || entry.token.ShouldRefresh() {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.token
+}
+
+func (c *TokenCache) put(key tokenCacheKey, token *authn.AuthnToken) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	// Sweep expired entries so that tokens for deleted workloads don't
+	// accumulate.
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = tokenCacheEntry{
+		token:     token,
+		expiresAt: now.Add(c.ttl),
+	}
+}
+
+func (c *TokenCache) delete(key tokenCacheKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, key)
+}
+
+// cachingAuthenticator implements conjurapi.Authenticator, returning a cached
+// access token when one is available and otherwise delegating authentication
+// to the wrapped Authenticator. The context of the mount request the client
+// was created for is kept, since the Authenticator interface doesn't take one.
+type cachingAuthenticator struct {
+	ctx           context.Context
+	key           tokenCacheKey
+	cache         *TokenCache
+	authenticator conjurapi.Authenticator
+}
+
+func (a *cachingAuthenticator) RefreshToken() ([]byte, error) {
+	if token := a.cache.get(a.key); token != nil {
+		logging.FromContext(a.ctx).Debug(logmessages.CKCP051)
+		return token.Raw(), nil
+	}
+
+	_, span := tracing.Tracer().Start(a.ctx, "conjur.Authenticate")
+	start := time.Now()
+	tokenBytes, err := a.authenticator.RefreshToken()
+	metrics.ConjurAuthenticationDuration.WithLabelValues(metrics.Result(err)).Observe(time.Since(start).Seconds())
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only cache tokens which the Conjur API client will accept
+	token, err := authn.NewToken(tokenBytes)
+	if err == nil {
+		a.cache.put(a.key, token)
+	}
+	return tokenBytes, nil
+}
+
+func (a *cachingAuthenticator) NeedsTokenRefresh() bool {
+	return a.authenticator.NeedsTokenRefresh()
+}
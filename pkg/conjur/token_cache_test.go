@@ -0,0 +1,191 @@
+package conjur
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-api-go/conjurapi"
+)
+
+type mockAuthenticator struct {
+	calls  int
+	tokens [][]byte
+	err    error
+}
+
+func (a *mockAuthenticator) RefreshToken() ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	token := a.tokens[a.calls%len(a.tokens)]
+	a.calls++
+	return token, nil
+}
+
+func (a *mockAuthenticator) NeedsTokenRefresh() bool {
+	return false
+}
+
+func accessToken(iat time.Time, lifespan time.Duration) []byte {
+	payload := fmt.Sprintf(`{"sub":"host/test","iat":%d,"exp":%d}`, iat.Unix(), iat.Add(lifespan).Unix())
+	return []byte(fmt.Sprintf(
+		`{"protected":"e30=","payload":"%s","signature":"c2ln"}`,
+		base64.StdEncoding.EncodeToString([]byte(payload)),
+	))
+}
+
+func TestCachingAuthenticator(t *testing.T) {
+	now := time.Now()
+	validToken := accessToken(now, 8*time.Minute)
+	staleToken := accessToken(now.Add(-7*time.Minute), 8*time.Minute)
+
+	key := newTokenCacheKey(conjurapi.Config{
+		ApplianceURL: "https://example.com",
+		Account:      "default",
+		ServiceID:    "kube",
+		JWTHostID:    "host/test",
+		JWTContent:   "jwt-token",
+	})
+	otherKey := key
+	otherKey.jwtHash = newTokenCacheKey(conjurapi.Config{JWTContent: "other-jwt"}).jwtHash
+
+	testCases := []struct {
+		name          string
+		tokens        [][]byte
+		err           error
+		run           func(*TokenCache, *mockAuthenticator) ([]byte, error)
+		expectedCalls int
+		expectedToken []byte
+		expectedError string
+	}{
+		{
+			name:   "Authenticates when cache is empty",
+			tokens: [][]byte{validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 1,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Reuses cached token for same identity",
+			tokens: [][]byte{validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 1,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Authenticates for a different JWT",
+			tokens: [][]byte{validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				return (&cachingAuthenticator{ctx: context.Background(), key: otherKey, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 2,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Authenticates after cache TTL elapses",
+			tokens: [][]byte{validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				c.now = func() time.Time { return now.Add(DefaultTokenCacheTTL) }
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 2,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Authenticates when cached token is due for refresh",
+			tokens: [][]byte{staleToken, validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 2,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Authenticates after token is evicted",
+			tokens: [][]byte{validToken},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				c.delete(key)
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 2,
+			expectedToken: validToken,
+		},
+		{
+			name:   "Does not cache unrecognized tokens",
+			tokens: [][]byte{[]byte("not a token")},
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				(&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedCalls: 2,
+			expectedToken: []byte("not a token"),
+		},
+		{
+			name: "Authentication error",
+			err:  fmt.Errorf("authn error"),
+			run: func(c *TokenCache, a *mockAuthenticator) ([]byte, error) {
+				return (&cachingAuthenticator{ctx: context.Background(), key: key, cache: c, authenticator: a}).RefreshToken()
+			},
+			expectedError: "authn error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := NewTokenCache(DefaultTokenCacheTTL)
+			cache.now = func() time.Time { return now }
+			authenticator := &mockAuthenticator{tokens: tc.tokens, err: tc.err}
+
+			token, err := tc.run(cache, authenticator)
+
+			if tc.expectedError != "" {
+				if err == nil || err.Error() != tc.expectedError {
+					t.Errorf("Expected error '%s', got '%v'", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if string(token) != string(tc.expectedToken) {
+				t.Errorf("Expected token %s, got %s", tc.expectedToken, token)
+			}
+			if authenticator.calls != tc.expectedCalls {
+				t.Errorf("Expected %d authentications, got %d", tc.expectedCalls, authenticator.calls)
+			}
+		})
+	}
+}
+
+func TestTokenCacheSweepsExpiredEntries(t *testing.T) {
+	now := time.Now()
+	cache := NewTokenCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	token := &mockAuthenticator{tokens: [][]byte{accessToken(now, 8*time.Minute)}}
+	first := tokenCacheKey{identity: "first"}
+	second := tokenCacheKey{identity: "second"}
+
+	(&cachingAuthenticator{ctx: context.Background(), key: first, cache: cache, authenticator: token}).RefreshToken()
+	cache.now = func() time.Time { return now.Add(2 * time.Minute) }
+	(&cachingAuthenticator{ctx: context.Background(), key: second, cache: cache, authenticator: token}).RefreshToken()
+
+	if _, ok := cache.entries[first]; ok {
+		t.Errorf("Expected expired entry to be swept")
+	}
+	if _, ok := cache.entries[second]; !ok {
+		t.Errorf("Expected new entry to be cached")
+	}
+}
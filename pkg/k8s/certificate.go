@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ConfigMapKind = "ConfigMap"
+	SecretKind    = "Secret"
+)
+
+// certificateCacheTTL bounds how long a certificate read from a ConfigMap or
+// Secret is reused, and so how long a rotated certificate takes to be used.
+const certificateCacheTTL = time.Minute
+
+type GetCertificateFunc func(namespace string, kind string, name string, key string) (string, error)
+
+type certificateCacheKey struct {
+	namespace string
+	kind      string
+	name      string
+	key       string
+}
+
+type certificateCacheEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+// GetCertificate returns the value of a key in a ConfigMap or Secret, such as
+// a Conjur CA certificate. Values are cached for a short time, since every
+// mount using the same SecretProviderClass reads the same certificate.
+func (c *Client) GetCertificate(namespace string, kind string, name string, key string) (string, error) {
+	cacheKey := certificateCacheKey{namespace: namespace, kind: kind, name: name, key: key}
+	now := c.now()
+
+	c.certificateMutex.Lock()
+	entry, ok := c.certificateEntries[cacheKey]
+	c.certificateMutex.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.value, nil
+	}
+
+	value, err := c.readCertificate(namespace, kind, name, key)
+	if err != nil {
+		return "", err
+	}
+
+	c.certificateMutex.Lock()
+	defer c.certificateMutex.Unlock()
+	for k, e := range c.certificateEntries {
+		if !now.Before(e.expiresAt) {
+			delete(c.certificateEntries, k)
+		}
+	}
+	c.certificateEntries[cacheKey] = certificateCacheEntry{
+		value:     value,
+		expiresAt: now.Add(certificateCacheTTL),
+	}
+
+	return value, nil
+}
+
+func (c *Client) readCertificate(namespace string, kind string, name string, key string) (string, error) {
+	var value string
+	var found bool
+	switch kind {
+	case ConfigMapKind:
+		configMap, err := c.clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		metrics.KubernetesRequests.WithLabelValues("configmaps", metrics.Result(err)).Inc()
+		if err != nil {
+			return "", logmessages.NewError(errorCategory(err), logmessages.CKCP056, kind, name, namespace, err)
+		}
+		value, found = configMap.Data[key]
+		if !found {
+			var binary []byte
+			binary, found = configMap.BinaryData[key]
+			value = string(binary)
+		}
+	case SecretKind:
+		secret, err := c.clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		metrics.KubernetesRequests.WithLabelValues("secrets", metrics.Result(err)).Inc()
+		if err != nil {
+			return "", logmessages.NewError(errorCategory(err), logmessages.CKCP056, kind, name, namespace, err)
+		}
+		var data []byte
+		data, found = secret.Data[key]
+		value = string(data)
+	default:
+		return "", fmt.Errorf("unsupported kind %q", kind)
+	}
+
+	if !found || value == "" {
+		return "", logmessages.Errorf(logmessages.CKCP057, key, kind, name, namespace)
+	}
+
+	return value, nil
+}
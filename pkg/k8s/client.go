@@ -2,28 +2,167 @@ package k8s
 
 import (
 	"context"
-	"fmt"
+	"sync"
+	"time"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
-type GetPodAnnotationsFunc func(namespace string, podName string) (map[string]string, error)
+type GetPodAnnotationsFunc func(namespace string, podName string, podUID string) (map[string]string, error)
 
-func GetPodAnnotations(namespace string, podName string) (map[string]string, error) {
-	kubeClient, _ := configK8sClient()
+// Client provides access to the Kubernetes resources needed to serve mount
+// requests. It shares a single clientset between all requests and, when
+// started with a node name, serves pod lookups from an informer cache holding
+// the pods scheduled to that node. Once started, it also records Events on
+// application pods.
+type Client struct {
+	clientset  kubernetes.Interface
+	nodeName   string
+	podLister  corelisters.PodLister
+	podsSynced cache.InformerSynced
+	stop       chan struct{}
+	stopOnce   sync.Once
 
-	pod, err := kubeClient.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+
+	certificateMutex   sync.Mutex
+	certificateEntries map[certificateCacheKey]certificateCacheEntry
+	now                func() time.Time
+}
+
+// NewClient creates a Client using the in-cluster Kubernetes configuration.
+// Pods are cached for the given node only, since the CSI driver only sends
+// mount requests for pods scheduled to the provider's own node. If nodeName is
+// empty, pods are read from the Kubernetes API on every request.
+func NewClient(nodeName string) (*Client, error) {
+	clientset, err := configK8sClient()
+	if err != nil {
+		return nil, err
+	}
+	return newClientWithDeps(clientset, nodeName), nil
+}
+
+func newClientWithDeps(clientset kubernetes.Interface, nodeName string) *Client {
+	return &Client{
+		clientset:          clientset,
+		nodeName:           nodeName,
+		stop:               make(chan struct{}),
+		certificateEntries: map[certificateCacheKey]certificateCacheEntry{},
+		now:                time.Now,
+	}
+}
+
+// Start begins recording Events and watching the pods scheduled to the
+// Client's node. Lookups fall back to the Kubernetes API until the cache has
+// synced.
+func (c *Client) Start() {
+	c.startEventRecorder()
+
+	if c.nodeName == "" {
+		log.Warn(logmessages.CKCP062)
+		return
+	}
+
+	log.Info(logmessages.CKCP061, c.nodeName)
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		c.clientset,
+		0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", c.nodeName).String()
+		}),
+	)
+	podInformer := factory.Core().V1().Pods()
+	// Only pod metadata is needed, so drop everything else to keep the cache
+	// small on nodes running many pods
+	podInformer.Informer().SetTransform(stripPod)
+
+	c.podLister = podInformer.Lister()
+	c.podsSynced = podInformer.Informer().HasSynced
+	factory.Start(c.stop)
+}
+
+// Stop ends the Client's pod watch, and flushes any pending Events.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		if c.broadcaster != nil {
+			c.broadcaster.Shutdown()
+		}
+	})
+}
+
+// GetPodAnnotations returns the annotations of a pod, preferably from the
+// pod cache. Pods missing from the cache are read from the Kubernetes API,
+// since a mount request may arrive before the watch has delivered a newly
+// scheduled pod. So are pods whose UID differs from podUID, when it's given,
+// since the cache may still hold a deleted pod which had the same name, such
+// as a StatefulSet's pod being replaced.
+func (c *Client) GetPodAnnotations(namespace string, podName string, podUID string) (map[string]string, error) {
+	if c.podLister != nil && c.podsSynced() {
+		pod, err := c.podLister.Pods(namespace).Get(podName)
+		if err == nil && (podUID == "" || string(pod.UID) == podUID) {
+			return pod.Annotations, nil
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, logmessages.NewError(errorCategory(err), logmessages.CKCP039, podName, namespace, err)
+		}
+	}
+
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(context.Background(), podName, metav1.GetOptions{})
+	metrics.KubernetesRequests.WithLabelValues("pods", metrics.Result(err)).Inc()
 	if err != nil {
-		return nil, fmt.Errorf(logmessages.CKCP039, podName, namespace, err.Error())
+		return nil, logmessages.NewError(errorCategory(err), logmessages.CKCP039, podName, namespace, err)
 	}
 
 	return pod.Annotations, nil
 }
 
+// errorCategory categorizes an error returned by the Kubernetes API.
+func errorCategory(err error) logmessages.Category {
+	switch {
+	case errors.IsNotFound(err):
+		return logmessages.CategoryNotFound
+	case errors.IsForbidden(err), errors.IsUnauthorized(err):
+		return logmessages.CategoryPermission
+	case errors.IsTimeout(err), errors.IsServerTimeout(err), errors.IsTooManyRequests(err),
+		errors.IsServiceUnavailable(err), errors.IsInternalError(err):
+		return logmessages.CategoryTransient
+	default:
+		return ""
+	}
+}
+
+// stripPod removes all but the metadata used by the provider from pods stored
+// in the informer cache.
+func stripPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+			Annotations:     pod.Annotations,
+		},
+	}, nil
+}
+
 func configK8sClient() (*kubernetes.Clientset, error) {
 	log.Info(logmessages.CKCP036)
 	kubeConfig, err := rest.InClusterConfig()
@@ -31,7 +170,7 @@ func configK8sClient() (*kubernetes.Clientset, error) {
 		// Error messages returned from K8s should be printed only in debug mode
 		log.Debug(err.Error())
 		log.Error(logmessages.CKCP037)
-		return nil, fmt.Errorf(logmessages.CKCP037)
+		return nil, logmessages.Errorf(logmessages.CKCP037)
 	}
 
 	kubeClient, err := kubernetes.NewForConfig(kubeConfig)
@@ -39,7 +178,7 @@ func configK8sClient() (*kubernetes.Clientset, error) {
 		// Error messages returned from K8s should be printed only in debug mode
 		log.Debug(err.Error())
 		log.Error(logmessages.CKCP038)
-		return nil, fmt.Errorf(logmessages.CKCP038)
+		return nil, logmessages.Errorf(logmessages.CKCP038)
 	}
 
 	return kubeClient, nil
@@ -0,0 +1,274 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testPod(name, nodeName string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "app-namespace",
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{NodeName: nodeName},
+	}
+}
+
+func withUID(pod *corev1.Pod, uid string) *corev1.Pod {
+	pod.UID = types.UID(uid)
+	return pod
+}
+
+// countGets counts the requests made to get individual resources, as opposed
+// to the list and watch requests made by informers.
+func countGets(clientset *fake.Clientset) *int {
+	gets := 0
+	clientset.PrependReactor("get", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+	return &gets
+}
+
+func TestGetPodAnnotations(t *testing.T) {
+	testCases := []struct {
+		description  string
+		nodeName     string
+		pods         []runtime.Object
+		unlistedPod  *corev1.Pod
+		podName      string
+		podUID       string
+		expected     map[string]string
+		expectedGets int
+		expectedErr  string
+	}{
+		{
+			description:  "served from cache",
+			nodeName:     "node-a",
+			pods:         []runtime.Object{testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- a"})},
+			podName:      "app",
+			expected:     map[string]string{"conjur.org/secrets": "- a"},
+			expectedGets: 0,
+		},
+		{
+			description:  "falls back to API for pods missing from cache",
+			nodeName:     "node-a",
+			unlistedPod:  testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- b"}),
+			podName:      "app",
+			expected:     map[string]string{"conjur.org/secrets": "- b"},
+			expectedGets: 1,
+		},
+		{
+			description:  "served from cache when the UID matches",
+			nodeName:     "node-a",
+			pods:         []runtime.Object{withUID(testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- a"}), "uid-a")},
+			podName:      "app",
+			podUID:       "uid-a",
+			expected:     map[string]string{"conjur.org/secrets": "- a"},
+			expectedGets: 0,
+		},
+		{
+			description:  "falls back to API for pods recreated with the same name",
+			nodeName:     "node-a",
+			pods:         []runtime.Object{withUID(testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- a"}), "uid-a")},
+			unlistedPod:  withUID(testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- b"}), "uid-b"),
+			podName:      "app",
+			podUID:       "uid-b",
+			expected:     map[string]string{"conjur.org/secrets": "- b"},
+			expectedGets: 1,
+		},
+		{
+			description:  "reads from API without node name",
+			pods:         []runtime.Object{testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- a"})},
+			podName:      "app",
+			expected:     map[string]string{"conjur.org/secrets": "- a"},
+			expectedGets: 1,
+		},
+		{
+			description:  "pod not found",
+			nodeName:     "node-a",
+			podName:      "missing",
+			expectedGets: 1,
+			expectedErr:  `Failed to get pod "missing" in namespace "app-namespace"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(tc.pods...)
+			if tc.unlistedPod != nil {
+				// Simulate a pod created after the cache was last updated
+				clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, tc.unlistedPod, nil
+				})
+			}
+			gets := countGets(clientset)
+			client := newClientWithDeps(clientset, tc.nodeName)
+			client.Start()
+			defer client.Stop()
+			if client.podsSynced != nil {
+				cache.WaitForCacheSync(client.stop, client.podsSynced)
+			}
+
+			annotations, err := client.GetPodAnnotations("app-namespace", tc.podName, tc.podUID)
+
+			if tc.expectedErr != "" {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expected, annotations)
+			}
+			assert.Equal(t, tc.expectedGets, *gets)
+		})
+	}
+}
+
+func TestStripPod(t *testing.T) {
+	pod := testPod("app", "node-a", map[string]string{"conjur.org/secrets": "- a"})
+	pod.Spec.Containers = []corev1.Container{{Name: "app"}}
+
+	stripped, err := stripPod(pod)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "app-namespace",
+			Annotations: map[string]string{"conjur.org/secrets": "- a"},
+		},
+	}, stripped)
+}
+
+func TestGetCertificate(t *testing.T) {
+	testCases := []struct {
+		description string
+		objects     []runtime.Object
+		kind        string
+		name        string
+		key         string
+		expected    string
+		expectedErr string
+	}{
+		{
+			description: "ConfigMap data",
+			objects: []runtime.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "conjur-ca", Namespace: "app-namespace"},
+				Data:       map[string]string{"ca.crt": "configmap certificate"},
+			}},
+			kind:     ConfigMapKind,
+			name:     "conjur-ca",
+			key:      "ca.crt",
+			expected: "configmap certificate",
+		},
+		{
+			description: "ConfigMap binary data",
+			objects: []runtime.Object{&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "conjur-ca", Namespace: "app-namespace"},
+				BinaryData: map[string][]byte{"ca.crt": []byte("binary certificate")},
+			}},
+			kind:     ConfigMapKind,
+			name:     "conjur-ca",
+			key:      "ca.crt",
+			expected: "binary certificate",
+		},
+		{
+			description: "Secret data",
+			objects: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "conjur-tls", Namespace: "app-namespace"},
+				Data:       map[string][]byte{"conjur.pem": []byte("secret certificate")},
+			}},
+			kind:     SecretKind,
+			name:     "conjur-tls",
+			key:      "conjur.pem",
+			expected: "secret certificate",
+		},
+		{
+			description: "missing key",
+			objects: []runtime.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "conjur-tls", Namespace: "app-namespace"},
+				Data:       map[string][]byte{"tls.crt": []byte("secret certificate")},
+			}},
+			kind:        SecretKind,
+			name:        "conjur-tls",
+			key:         "ca.crt",
+			expectedErr: `Key "ca.crt" not found or empty in Secret "conjur-tls" in namespace "app-namespace"`,
+		},
+		{
+			description: "missing object",
+			kind:        ConfigMapKind,
+			name:        "conjur-ca",
+			key:         "ca.crt",
+			expectedErr: `Failed to get ConfigMap "conjur-ca" in namespace "app-namespace"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			client := newClientWithDeps(fake.NewSimpleClientset(tc.objects...), "")
+
+			value, err := client.GetCertificate("app-namespace", tc.kind, tc.name, tc.key)
+
+			if tc.expectedErr != "" {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestGetCertificateCaching(t *testing.T) {
+	now := time.Now()
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "conjur-ca", Namespace: "app-namespace"},
+		Data:       map[string]string{"ca.crt": "certificate"},
+	})
+	gets := countGets(clientset)
+	client := newClientWithDeps(clientset, "")
+	client.now = func() time.Time { return now }
+
+	client.GetCertificate("app-namespace", ConfigMapKind, "conjur-ca", "ca.crt")
+	client.GetCertificate("app-namespace", ConfigMapKind, "conjur-ca", "ca.crt")
+	assert.Equal(t, 1, *gets)
+
+	client.now = func() time.Time { return now.Add(certificateCacheTTL) }
+	client.GetCertificate("app-namespace", ConfigMapKind, "conjur-ca", "ca.crt")
+	assert.Equal(t, 2, *gets)
+}
+
+func TestErrorCategory(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+	testCases := []struct {
+		description string
+		err         error
+		expected    logmessages.Category
+	}{
+		{description: "not found", err: errors.NewNotFound(pods, "app"), expected: logmessages.CategoryNotFound},
+		{description: "forbidden", err: errors.NewForbidden(pods, "app", nil), expected: logmessages.CategoryPermission},
+		{description: "unauthorized", err: errors.NewUnauthorized("expired token"), expected: logmessages.CategoryPermission},
+		{description: "timeout", err: errors.NewTimeoutError("timed out", 1), expected: logmessages.CategoryTransient},
+		{description: "too many requests", err: errors.NewTooManyRequests("slow down", 1), expected: logmessages.CategoryTransient},
+		{description: "invalid", err: errors.NewBadRequest("invalid"), expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, errorCategory(tc.err))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"strings"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// eventSourceComponent identifies the provider as the source of the
+	// Events it records.
+	eventSourceComponent = "conjur-k8s-csi-provider"
+	// MountFailedReason is the reason given for Events recorded when a mount
+	// request fails.
+	MountFailedReason = "ConjurMountFailed"
+	// maxEventMessageLength bounds the length of Event messages, which are
+	// read by application teams rather than provider administrators.
+	maxEventMessageLength = 512
+)
+
+// startEventRecorder begins sending Events recorded by the Client to the
+// Kubernetes API.
+func (c *Client) startEventRecorder() {
+	c.broadcaster = record.NewBroadcaster()
+	c.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: c.clientset.CoreV1().Events(""),
+	})
+	c.recorder = c.broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{
+		Component: eventSourceComponent,
+		Host:      c.nodeName,
+	})
+}
+
+// RecordMountFailure records a Warning Event on an application pod describing
+// why a mount request for it failed, so that application teams can see the
+// failure without access to the provider's logs.
+func (c *Client) RecordMountFailure(namespace string, podName string, err error) {
+	if c.recorder == nil || namespace == "" || podName == "" || err == nil {
+		return
+	}
+
+	pod := &corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  namespace,
+		Name:       podName,
+	}
+	if c.podLister != nil {
+		if cached, err := c.podLister.Pods(namespace).Get(podName); err == nil {
+			pod.UID = cached.UID
+		}
+	}
+
+	c.recorder.Event(pod, corev1.EventTypeWarning, MountFailedReason, eventMessage(err))
+}
+
+// eventMessage describes a mount failure by its most specific CKCP message,
+// omitting the messages it was wrapped in on the way up the call stack, on a
+// single line of bounded length.
+func eventMessage(err error) string {
+	message := err.Error()
+	if code := metrics.ErrorCode(err); code != "" {
+		message = message[strings.LastIndex(message, code):]
+	}
+	message = strings.Join(strings.Fields(message), " ")
+
+	if runes := []rune(message); len(runes) > maxEventMessageLength {
+		message = string(runes[:maxEventMessageLength-3]) + "..."
+	}
+	return message
+}
@@ -0,0 +1,91 @@
+package k8s
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestRecordMountFailure(t *testing.T) {
+	testCases := []struct {
+		description string
+		namespace   string
+		podName     string
+		err         error
+		expected    []string
+	}{
+		{
+			description: "records warning event",
+			namespace:   "app-namespace",
+			podName:     "app",
+			err:         errors.New(`CKCP034 Annotation "conjur.org/secrets" missing or empty`),
+			expected:    []string{`Warning ConjurMountFailed CKCP034 Annotation "conjur.org/secrets" missing or empty`},
+		},
+		{
+			description: "no event without pod",
+			namespace:   "app-namespace",
+			err:         errors.New(`CKCP034 Annotation "conjur.org/secrets" missing or empty`),
+		},
+		{
+			description: "no event without error",
+			namespace:   "app-namespace",
+			podName:     "app",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			client := newClientWithDeps(fake.NewSimpleClientset(), "")
+			client.recorder = recorder
+
+			client.RecordMountFailure(tc.namespace, tc.podName, tc.err)
+			close(recorder.Events)
+
+			events := []string{}
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			assert.ElementsMatch(t, tc.expected, events)
+		})
+	}
+}
+
+func TestEventMessage(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		expected    string
+	}{
+		{
+			description: "most specific message",
+			err:         errors.New(`CKCP035 Failed to retrieve pod annotations: CKCP039 Failed to get pod "app" in namespace "app-namespace": not found`),
+			expected:    `CKCP039 Failed to get pod "app" in namespace "app-namespace": not found`,
+		},
+		{
+			description: "message without code",
+			err:         errors.New("unexpected failure"),
+			expected:    "unexpected failure",
+		},
+		{
+			description: "collapses whitespace",
+			err:         errors.New("CKCP031 Failed to retrieve batch secrets:\n  timeout\n"),
+			expected:    "CKCP031 Failed to retrieve batch secrets: timeout",
+		},
+		{
+			description: "truncates long messages",
+			err:         errors.New("CKCP031 " + strings.Repeat("a", 1000)),
+			expected:    "CKCP031 " + strings.Repeat("a", maxEventMessageLength-11) + "...",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, eventMessage(tc.err))
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	stdlog "log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+)
+
+// Supported values of the -logFormat flag.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Keys of the fields attached to request-scoped log lines.
+const (
+	PodKey                 = "pod"
+	NamespaceKey           = "namespace"
+	SecretProviderClassKey = "secretProviderClass"
+	RequestIDKey           = "requestId"
+	CodeKey                = "code"
+	CallerKey              = "caller"
+)
+
+var codePattern = regexp.MustCompile(`^CKCP\d{3}`)
+
+// mutex guards the format and level settings.
+var (
+	mutex    sync.Mutex
+	format   = FormatText
+	logLevel = "info"
+)
+
+// Outputs of JSON lines written by Loggers, replaced in tests.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// SetFormat sets the format of all log lines written by the provider,
+// including those written through the conjur-authn-k8s-client log package.
+func SetFormat(f string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	switch f {
+	case FormatText:
+		log.InfoLogger = stdlog.New(stdout, "INFO:  ", stdlog.LUTC|stdlog.Ldate|stdlog.Ltime|stdlog.Lmicroseconds|stdlog.Lshortfile)
+		log.ErrorLogger = stdlog.New(stderr, "ERROR: ", stdlog.LUTC|stdlog.Ldate|stdlog.Ltime|stdlog.Lmicroseconds|stdlog.Lshortfile)
+	case FormatJSON:
+		// The log package sets each line's prefix to its level, which is
+		// parsed back out of the line when it's converted to JSON
+		log.InfoLogger = stdlog.New(&jsonLineWriter{out: stdout}, "", stdlog.Lshortfile)
+		log.ErrorLogger = stdlog.New(&jsonLineWriter{out: stderr}, "", stdlog.Lshortfile)
+	default:
+		return fmt.Errorf("unsupported log format %q, expected %q or %q", f, FormatText, FormatJSON)
+	}
+	format = f
+	return nil
+}
+
+// SetLogLevel sets the minimum level of the lines logged by both request
+// loggers and the conjur-authn-k8s-client log package.
+func SetLogLevel(level string) {
+	mutex.Lock()
+	logLevel = level
+	mutex.Unlock()
+
+	log.SetLogLevel(level)
+}
+
+func enabled(level slog.Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	switch logLevel {
+	case "debug":
+		return true
+	case "info":
+		return level >= slog.LevelInfo
+	case "warn":
+		return level >= slog.LevelWarn
+	default:
+		return level >= slog.LevelError
+	}
+}
+
+// Logger writes log lines carrying a fixed set of fields, such as the pod
+// and namespace of the mount request being served. A nil Logger writes lines
+// without fields.
+type Logger struct {
+	fields []slog.Attr
+}
+
+// With returns a Logger which adds a field to every line written by l.
+func (l *Logger) With(key string, value string) *Logger {
+	logger := &Logger{}
+	if l != nil {
+		logger.fields = append(logger.fields, l.fields...)
+	}
+	logger.fields = append(logger.fields, slog.String(key, value))
+	return logger
+}
+
+func (l *Logger) Error(message string, args ...interface{}) {
+	l.write(slog.LevelError, message, args...)
+}
+
+func (l *Logger) Warn(message string, args ...interface{}) {
+	l.write(slog.LevelWarn, message, args...)
+}
+
+func (l *Logger) Info(message string, args ...interface{}) {
+	l.write(slog.LevelInfo, message, args...)
+}
+
+func (l *Logger) Debug(message string, args ...interface{}) {
+	l.write(slog.LevelDebug, message, args...)
+}
+
+func (l *Logger) write(level slog.Level, message string, args ...interface{}) {
+	if !enabled(level) {
+		return
+	}
+	if len(args) > 0 {
+		message = fmt.Sprintf(message, args...)
+	}
+
+	var fields []slog.Attr
+	if l != nil {
+		fields = l.fields
+	}
+
+	mutex.Lock()
+	jsonFormat := format == FormatJSON
+	mutex.Unlock()
+
+	if jsonFormat {
+		caller := ""
+		if _, file, line, ok := runtime.Caller(2); ok {
+			caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+		}
+		out := stdout
+		if level >= slog.LevelError {
+			out = stderr
+		}
+		writeJSON(out, level, caller, message, fields)
+		return
+	}
+
+	// Match the lines written by the log package, with fields appended
+	logger := log.InfoLogger
+	if level >= slog.LevelError {
+		logger = log.ErrorLogger
+	}
+	var line strings.Builder
+	line.WriteString(message)
+	for _, field := range fields {
+		fmt.Fprintf(&line, " %s=%q", field.Key, field.Value.String())
+	}
+	logger.SetPrefix(fmt.Sprintf("%-7s", levelName(level)+":"))
+	logger.Output(3, line.String())
+}
+
+// writeJSON writes a single log line as a JSON object.
+func writeJSON(out io.Writer, level slog.Level, caller string, message string, fields []slog.Attr) {
+	handler := slog.NewJSONHandler(out, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			switch attr.Key {
+			case slog.TimeKey:
+				return slog.String(slog.TimeKey, attr.Value.Time().UTC().Format(time.RFC3339Nano))
+			case slog.LevelKey:
+				return slog.String(slog.LevelKey, strings.ToLower(levelName(level)))
+			}
+			return attr
+		},
+	})
+
+	record := slog.NewRecord(time.Now(), level, message, 0)
+	if caller != "" {
+		record.AddAttrs(slog.String(CallerKey, caller))
+	}
+	if code := codePattern.FindString(message); code != "" {
+		record.AddAttrs(slog.String(CodeKey, code))
+	}
+	record.AddAttrs(fields...)
+	_ = handler.Handle(context.Background(), record)
+}
+
+func levelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARN"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// jsonLineWriter converts the lines written by the conjur-authn-k8s-client log
+// package, formatted as "LEVEL: file.go:123: message", to JSON.
+type jsonLineWriter struct {
+	out io.Writer
+}
+
+func (w *jsonLineWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimSuffix(p, []byte("\n")))
+
+	level := slog.LevelInfo
+	if name, rest, ok := strings.Cut(line, ":"); ok {
+		switch strings.TrimSpace(name) {
+		case "ERROR":
+			level = slog.LevelError
+		case "WARN":
+			level = slog.LevelWarn
+		case "INFO":
+			level = slog.LevelInfo
+		case "DEBUG":
+			level = slog.LevelDebug
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+
+	caller := ""
+	if file, rest, ok := strings.Cut(line, ": "); ok && strings.Contains(file, ".go:") {
+		caller = file
+		line = rest
+	}
+
+	writeJSON(w.out, level, caller, line, nil)
+	return len(p), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the Logger carried by ctx, or a Logger without fields
+// if there is none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return logger
+		}
+	}
+	return &Logger{}
+}
+
+// NewRequestID returns a random identifier used to correlate the log lines
+// written while serving a single request.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureOutput directs all log lines to buffers for the duration of a test.
+func captureOutput(t *testing.T, format string) (*bytes.Buffer, *bytes.Buffer) {
+	var out, errOut bytes.Buffer
+	originalStdout, originalStderr := stdout, stderr
+	stdout, stderr = &out, &errOut
+	assert.Nil(t, SetFormat(format))
+	SetLogLevel("info")
+
+	t.Cleanup(func() {
+		stdout, stderr = originalStdout, originalStderr
+		SetFormat(FormatText)
+	})
+	return &out, &errOut
+}
+
+func decodeLine(t *testing.T, line []byte) map[string]string {
+	fields := map[string]string{}
+	assert.Nil(t, json.Unmarshal(line, &fields))
+	return fields
+}
+
+func TestLoggerJSON(t *testing.T) {
+	out, errOut := captureOutput(t, FormatJSON)
+	logger := (&Logger{}).With(PodKey, "app").With(NamespaceKey, "app-namespace")
+
+	logger.Info("CKCP045 All %d secrets match their currently mounted versions", 2)
+	logger.Error("CKCP034 Annotation %q missing or empty", "conjur.org/secrets")
+	logger.Debug("not logged at info level")
+
+	info := decodeLine(t, out.Bytes())
+	assert.Equal(t, "info", info["level"])
+	assert.Equal(t, "CKCP045 All 2 secrets match their currently mounted versions", info["msg"])
+	assert.Equal(t, "CKCP045", info[CodeKey])
+	assert.Equal(t, "app", info[PodKey])
+	assert.Equal(t, "app-namespace", info[NamespaceKey])
+	assert.Regexp(t, `^logging_test\.go:\d+$`, info[CallerKey])
+
+	failure := decodeLine(t, errOut.Bytes())
+	assert.Equal(t, "error", failure["level"])
+	assert.Equal(t, "CKCP034", failure[CodeKey])
+	assert.Equal(t, "app", failure[PodKey])
+}
+
+func TestLoggerText(t *testing.T) {
+	out, _ := captureOutput(t, FormatText)
+	logger := (&Logger{}).With(RequestIDKey, "abc123")
+
+	logger.Warn("CKCP042 Secrets defined in the SecretProviderClass")
+
+	assert.Regexp(t, `^WARN:  .* logging_test\.go:\d+: CKCP042 Secrets defined in the SecretProviderClass requestId="abc123"\n$`, out.String())
+}
+
+func TestLogPackageJSON(t *testing.T) {
+	out, errOut := captureOutput(t, FormatJSON)
+
+	log.Info("CKCP021 Serving gRPC server on socket %s", "/tmp/conjur.sock")
+	log.Error("CKCP020 Failed to listen on socket: %s", "address in use")
+
+	info := decodeLine(t, out.Bytes())
+	assert.Equal(t, "info", info["level"])
+	assert.Equal(t, "CKCP021 Serving gRPC server on socket /tmp/conjur.sock", info["msg"])
+	assert.Equal(t, "CKCP021", info[CodeKey])
+	assert.Regexp(t, `^logging_test\.go:\d+$`, info[CallerKey])
+
+	failure := decodeLine(t, errOut.Bytes())
+	assert.Equal(t, "error", failure["level"])
+	assert.Equal(t, "CKCP020 Failed to listen on socket: address in use", failure["msg"])
+}
+
+func TestSetFormat(t *testing.T) {
+	captureOutput(t, FormatText)
+
+	assert.Nil(t, SetFormat(FormatJSON))
+	assert.EqualError(t, SetFormat("xml"), `unsupported log format "xml", expected "text" or "json"`)
+}
+
+func TestFromContext(t *testing.T) {
+	logger := (&Logger{}).With(RequestIDKey, "abc123")
+
+	assert.Same(t, logger, FromContext(NewContext(context.Background(), logger)))
+	assert.Empty(t, FromContext(context.Background()).fields)
+}
@@ -0,0 +1,178 @@
+package logmessages
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Category groups errors by what needs to happen for a failed request to
+// succeed, such as fixing its configuration or waiting out an outage.
+type Category string
+
+const (
+	// CategoryConfig errors are caused by invalid SecretProviderClass
+	// parameters, pod annotations or mount request attributes.
+	CategoryConfig Category = "config"
+	// CategoryAuth errors are caused by Conjur rejecting the workload's
+	// credentials.
+	CategoryAuth Category = "auth"
+	// CategoryNotFound errors are caused by a missing Conjur variable or
+	// Kubernetes resource.
+	CategoryNotFound Category = "not-found"
+	// CategoryPermission errors are caused by the workload, or the provider
+	// itself, lacking access to a Conjur variable or Kubernetes resource.
+	CategoryPermission Category = "permission"
+	// CategoryTransient errors are caused by Conjur or Kubernetes being
+	// temporarily unavailable, and may succeed when retried.
+	CategoryTransient Category = "transient"
+	// CategoryOverloaded errors are caused by the provider serving as many
+	// mount requests as it's allowed to at once, and may succeed when retried.
+	CategoryOverloaded Category = "overloaded"
+)
+
+// categories gives the category of each error message whose cause doesn't
+// vary. Messages which only add context to a wrapped error have no category,
+// and errors whose cause depends on a response from Conjur or Kubernetes are
+// categorized where they are created.
+var categories = map[string]Category{
+	"CKCP006": CategoryConfig,
+	"CKCP007": CategoryConfig,
+	"CKCP008": CategoryConfig,
+	"CKCP009": CategoryConfig,
+	"CKCP010": CategoryConfig,
+	"CKCP011": CategoryConfig,
+	"CKCP012": CategoryConfig,
+	"CKCP014": CategoryConfig,
+	"CKCP017": CategoryConfig,
+	"CKCP030": CategoryConfig,
+	"CKCP032": CategoryConfig,
+	"CKCP033": CategoryConfig,
+	"CKCP034": CategoryConfig,
+	"CKCP046": CategoryConfig,
+	"CKCP047": CategoryConfig,
+	"CKCP048": CategoryConfig,
+	"CKCP050": CategoryNotFound,
+	"CKCP053": CategoryConfig,
+	"CKCP055": CategoryConfig,
+	"CKCP057": CategoryNotFound,
+	"CKCP058": CategoryConfig,
+	"CKCP073": CategoryConfig,
+	"CKCP076": CategoryConfig,
+	"CKCP084": CategoryOverloaded,
+}
+
+var codePattern = regexp.MustCompile(`^CKCP\d{3}`)
+
+// Error is an error described by one of the CKCP messages.
+type Error struct {
+	// Code is the CKCP code of the message.
+	Code string
+	// Category is the category of the error itself, which may be empty when
+	// it's given by a wrapped error instead. Use CategoryOf to categorize an
+	// error.
+	Category Category
+	// Err is the error wrapped by this one, if any.
+	Err error
+
+	message string
+}
+
+// Errorf formats a CKCP message as an Error, categorized according to its
+// code. The first error in args, whether formatted with %w or %v, is wrapped.
+func Errorf(format string, args ...interface{}) *Error {
+	return NewError(categories[codePattern.FindString(format)], format, args...)
+}
+
+// NewError formats a CKCP message as an Error with the given category. The
+// first error in args, whether formatted with %w or %v, is wrapped.
+func NewError(category Category, format string, args ...interface{}) *Error {
+	e := &Error{
+		Code:     codePattern.FindString(format),
+		Category: category,
+		message:  fmt.Errorf(format, args...).Error(),
+	}
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			e.Err = err
+			break
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) ErrorCategory() Category {
+	return e.Category
+}
+
+// GRPCStatus allows an Error to be returned from a gRPC handler, with the
+// status code of its category.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(CategoryOf(e).GRPCCode(), e.message)
+}
+
+// categorizedError is implemented by errors which know their own category,
+// including Error.
+type categorizedError interface {
+	ErrorCategory() Category
+}
+
+// CategoryOf returns the category of the most deeply wrapped categorized
+// error in err's chain, which is the one closest to the original failure. It
+// returns an empty Category if none of them are categorized.
+func CategoryOf(err error) Category {
+	var category Category
+	for ; err != nil; err = errors.Unwrap(err) {
+		if categorized, ok := err.(categorizedError); ok && categorized.ErrorCategory() != "" {
+			category = categorized.ErrorCategory()
+		}
+	}
+	return category
+}
+
+// GRPCCode returns the gRPC status code reported for errors in a category.
+func (c Category) GRPCCode() codes.Code {
+	switch c {
+	case CategoryConfig:
+		return codes.InvalidArgument
+	case CategoryAuth:
+		return codes.Unauthenticated
+	case CategoryNotFound:
+		return codes.NotFound
+	case CategoryPermission:
+		return codes.PermissionDenied
+	case CategoryTransient:
+		return codes.Unavailable
+	case CategoryOverloaded:
+		return codes.ResourceExhausted
+	default:
+		return codes.Unknown
+	}
+}
+
+// GRPCError converts an error into a gRPC status error, with the status code
+// of its category and its full message. Uncategorized errors which already
+// carry a gRPC status keep it.
+func GRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	category := CategoryOf(err)
+	if category == "" {
+		if s, ok := status.FromError(err); ok {
+			return s.Err()
+		}
+	}
+	return status.Error(category.GRPCCode(), err.Error())
+}
@@ -0,0 +1,148 @@
+package logmessages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type conjurError struct {
+	category Category
+}
+
+func (e *conjurError) Error() string {
+	return "conjur error"
+}
+
+func (e *conjurError) ErrorCategory() Category {
+	return e.category
+}
+
+func TestErrorf(t *testing.T) {
+	cause := errors.New("invalid character")
+
+	err := Errorf(CKCP012, cause)
+
+	assert.Equal(t, "CKCP012", err.Code)
+	assert.Equal(t, CategoryConfig, err.Category)
+	assert.Equal(t, "CKCP012 Failed to unmarshal file permissions: invalid character", err.Error())
+	assert.True(t, errors.Is(err, cause))
+
+	// Errors formatted with %v are wrapped too
+	err = Errorf(CKCP035, cause)
+	assert.Equal(t, "CKCP035", err.Code)
+	assert.Empty(t, err.Category)
+	assert.True(t, errors.Is(err, cause))
+}
+
+func TestCategoryOf(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		expected    Category
+	}{
+		{
+			description: "categorized error",
+			err:         Errorf(CKCP034, "conjur.org/secrets"),
+			expected:    CategoryConfig,
+		},
+		{
+			description: "wrapper takes category of wrapped error",
+			err:         Errorf(CKCP013, Errorf(CKCP034, "conjur.org/secrets")),
+			expected:    CategoryConfig,
+		},
+		{
+			description: "most deeply wrapped category wins",
+			err:         Errorf(CKCP033, NewError(CategoryPermission, CKCP039, "app", "app-namespace", errors.New("forbidden"))),
+			expected:    CategoryPermission,
+		},
+		{
+			description: "other categorized errors",
+			err:         Errorf(CKCP016, &conjurError{category: CategoryAuth}),
+			expected:    CategoryAuth,
+		},
+		{
+			description: "wrapped by fmt",
+			err:         fmt.Errorf("mount failed: %w", Errorf(CKCP050, "conjur/path")),
+			expected:    CategoryNotFound,
+		},
+		{
+			description: "uncategorized error",
+			err:         Errorf(CKCP016, errors.New("unexpected")),
+		},
+		{
+			description: "no error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, CategoryOf(tc.err))
+		})
+	}
+}
+
+func TestGRPCError(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		expected    codes.Code
+	}{
+		{
+			description: "config error",
+			err:         Errorf(CKCP013, Errorf(CKCP009, []string{"account"})),
+			expected:    codes.InvalidArgument,
+		},
+		{
+			description: "auth error",
+			err:         &conjurError{category: CategoryAuth},
+			expected:    codes.Unauthenticated,
+		},
+		{
+			description: "not found error",
+			err:         Errorf(CKCP050, "conjur/path"),
+			expected:    codes.NotFound,
+		},
+		{
+			description: "permission error",
+			err:         &conjurError{category: CategoryPermission},
+			expected:    codes.PermissionDenied,
+		},
+		{
+			description: "transient error",
+			err:         Errorf(CKCP016, &conjurError{category: CategoryTransient}),
+			expected:    codes.Unavailable,
+		},
+		{
+			description: "overloaded error",
+			err:         Errorf(CKCP084, 10, "5s", context.DeadlineExceeded),
+			expected:    codes.ResourceExhausted,
+		},
+		{
+			description: "uncategorized error",
+			err:         errors.New("unexpected"),
+			expected:    codes.Unknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := GRPCError(tc.err)
+
+			s, ok := status.FromError(err)
+			assert.True(t, ok)
+			assert.Equal(t, tc.expected, s.Code())
+			assert.Equal(t, tc.err.Error(), s.Message())
+		})
+	}
+
+	// Uncategorized errors keep any status they already carry
+	s, _ := status.FromError(GRPCError(status.Error(codes.DeadlineExceeded, "timed out")))
+	assert.Equal(t, codes.DeadlineExceeded, s.Code())
+	assert.Equal(t, "timed out", s.Message())
+}
@@ -51,3 +51,53 @@ const CKCP039 string = "CKCP039 Failed to get pod \"%s\" in namespace \"%s\": %v
 const CKCP040 string = "CKCP040 Provided configuration version: %v"
 const CKCP041 string = "CKCP041 Configuration version not provided. Defaulting to: %v"
 const CKCP042 string = "CKCP042 Defining secrets in the SecretProviderClass is deprecated in v0.2.0 and greater. Please use the 'conjur.org/secrets' annotation in the pod spec."
+const CKCP043 string = "CKCP043 Unable to determine version of %q, using content hash: %v"
+const CKCP044 string = "CKCP044 Secrets unchanged since last mount, skipping retrieval"
+const CKCP045 string = "CKCP045 All %d secrets match their currently mounted versions, leaving files unchanged"
+const CKCP046 string = "CKCP046 Invalid secret group %q: %v"
+const CKCP047 string = "CKCP047 Failed to render secret file %q: %v"
+const CKCP048 string = "CKCP048 Failed to parse template for secret group %q: %v"
+const CKCP050 string = "CKCP050 No variables found under policy branch %q"
+const CKCP051 string = "CKCP051 Using cached Conjur access token"
+const CKCP052 string = "CKCP052 Transient error from Conjur, retrying in %s (retry %d of %d): %v"
+const CKCP053 string = "CKCP053 Invalid value %q for attribute %q: %v"
+const CKCP054 string = "CKCP054 Conjur appliance %q is unavailable, failing over to %q: %v"
+const CKCP055 string = "CKCP055 Only one of %q may be provided"
+const CKCP056 string = "CKCP056 Failed to get %s \"%s\" in namespace \"%s\": %v"
+const CKCP057 string = "CKCP057 Key %q not found or empty in %s %q in namespace %q"
+const CKCP058 string = "CKCP058 Invalid value %q for attribute %q, expected \"<name>\" or \"<name>/<key>\""
+const CKCP059 string = "CKCP059 Failed to load Conjur SSL certificate: %v"
+const CKCP060 string = "CKCP060 Conjur appliance %q failed readiness check: %v"
+const CKCP061 string = "CKCP061 Caching pods scheduled to node %q"
+const CKCP062 string = "CKCP062 NODE_NAME is not set, pods will be read from the Kubernetes API on every mount"
+const CKCP063 string = "CKCP063 Failed to create Kubernetes client: %v"
+const CKCP064 string = "CKCP064 Invalid log format: %s. Defaulting to text"
+const CKCP065 string = "CKCP065 Exporting traces to OTLP collector at %s"
+const CKCP066 string = "CKCP066 Failed to start tracing, continuing without it: %v"
+const CKCP067 string = "CKCP067 Failed to flush traces: %v"
+const CKCP068 string = "CKCP068 Failed to parse manifest %q: %v"
+const CKCP069 string = "CKCP069 SecretProviderClass %q was not found in the given manifests, or doesn't use the Conjur provider"
+const CKCP070 string = "CKCP070 SecretProviderClass is not mounted by any pod in the given manifests, so its secrets were not validated"
+const CKCP071 string = "CKCP071 No SecretProviderClass using the Conjur provider found in manifest %q"
+const CKCP072 string = "CKCP072 No pod or workload found in manifest %q"
+const CKCP073 string = "CKCP073 Can't read %s %q from outside of a cluster, provide the certificate directly instead"
+const CKCP074 string = "CKCP074 Refusing to write file %q outside of the output directory"
+const CKCP075 string = "CKCP075 Failed to write file %q: %v"
+const CKCP076 string = "CKCP076 Invalid secret file path %q: %s"
+const CKCP077 string = "CKCP077 Serving admission webhook on port %d..."
+const CKCP078 string = "CKCP078 Admission webhook failed: %v"
+const CKCP079 string = "CKCP079 Failed to stop the admission webhook: %v"
+const CKCP080 string = "CKCP080 Failed to decode admission review: %v"
+const CKCP081 string = "CKCP081 Rejected pod: %v"
+const CKCP082 string = "CKCP082 Recovered from panic while serving %s: %v"
+const CKCP083 string = "CKCP083 Served %s in %s with code %s"
+const CKCP084 string = "CKCP084 Gave up waiting for one of %d concurrent mount slots after %s: %v"
+const CKCP085 string = "CKCP085 Removed stale socket %s"
+const CKCP086 string = "CKCP086 Socket %s is in use by another process"
+const CKCP087 string = "CKCP087 Refusing to replace %s, which isn't a socket"
+const CKCP088 string = "CKCP088 Failed to secure socket %s: %w"
+const CKCP089 string = "CKCP089 Draining gRPC server, reporting not ready..."
+const CKCP090 string = "CKCP090 Gave up draining gRPC server after %s, stopping %d in-flight mount requests"
+const CKCP091 string = "CKCP091 Abandoned mount request for pod %q in namespace %q after %s"
+const CKCP092 string = "CKCP092 Failed to list Conjur variables: %v"
+const CKCP093 string = "CKCP093 Failed to check whether socket %s is in use: %w"
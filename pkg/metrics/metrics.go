@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "conjur_csi_provider"
+
+// Values of the "result" label.
+const (
+	ResultSuccess   = "success"
+	ResultUnchanged = "unchanged"
+	ResultError     = "error"
+)
+
+// Registry holds all of the provider's metrics, along with Go runtime and
+// process metrics, and is served by the health server's /metrics endpoint.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// MountRequests counts mount requests by application namespace, result,
+	// and the CKCP code and category of the error for failed requests.
+	MountRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mount_requests_total",
+		Help:      "Number of mount requests handled, by application namespace, result, error code and error category.",
+	}, []string{"namespace", "result", "code", "category"})
+
+	// MountDuration observes the time taken to handle mount requests.
+	MountDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mount_duration_seconds",
+		Help:      "Time taken to handle mount requests, by application namespace and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "result"})
+
+	// MountSecrets observes the number of secrets returned by successful
+	// mount requests.
+	MountSecrets = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mount_secrets",
+		Help:      "Number of secrets returned by successful mount requests, by application namespace.",
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500},
+	}, []string{"namespace"})
+
+	// MountQueueDuration observes the time mount requests wait for one of the
+	// provider's concurrent mount slots, including requests which give up.
+	MountQueueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mount_queue_duration_seconds",
+		Help:      "Time mount requests wait for a concurrent mount slot, by application namespace and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "result"})
+
+	// MountsInFlight is the number of mount requests currently being served.
+	MountsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mounts_in_flight",
+		Help:      "Number of mount requests currently being served.",
+	})
+
+	// MountsQueued is the number of mount requests waiting for a concurrent
+	// mount slot.
+	MountsQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mounts_queued",
+		Help:      "Number of mount requests waiting for a concurrent mount slot.",
+	})
+
+	// ConjurAuthenticationDuration observes the time taken to authenticate
+	// with Conjur. Access tokens served from the token cache aren't observed.
+	ConjurAuthenticationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "conjur_authentication_duration_seconds",
+		Help:      "Time taken to authenticate with Conjur, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	// ConjurBatchRetrievalDuration observes the time taken by each batch
+	// secret retrieval request, including retries.
+	ConjurBatchRetrievalDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "conjur_batch_retrieval_duration_seconds",
+		Help:      "Time taken by batch secret retrieval requests to Conjur, by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	// GRPCRequestDuration observes the time taken to serve each gRPC request
+	// from the CSI driver, including Version requests.
+	GRPCRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Time taken to serve gRPC requests from the CSI driver, by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	// KubernetesRequests counts requests made to the Kubernetes API.
+	KubernetesRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kubernetes_requests_total",
+		Help:      "Number of requests made to the Kubernetes API, by resource and result.",
+	}, []string{"resource", "result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		MountRequests,
+		MountDuration,
+		MountSecrets,
+		MountQueueDuration,
+		MountsInFlight,
+		MountsQueued,
+		ConjurAuthenticationDuration,
+		ConjurBatchRetrievalDuration,
+		GRPCRequestDuration,
+		KubernetesRequests,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var errorCodePattern = regexp.MustCompile(`CKCP\d{3}`)
+
+// Result returns the "result" label value for an operation's error.
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
+
+// ErrorCode returns the most specific CKCP code found in an error message.
+// Errors are wrapped as they are returned up the call stack, so the last code
+// in the message is the one closest to the original failure.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	codes := errorCodePattern.FindAllString(err.Error(), -1)
+	if len(codes) == 0 {
+		return ""
+	}
+	return codes[len(codes)-1]
+}
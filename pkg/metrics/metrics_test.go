@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	testCases := []struct {
+		description string
+		err         error
+		expected    string
+	}{
+		{
+			description: "no error",
+			err:         nil,
+			expected:    "",
+		},
+		{
+			description: "error without code",
+			err:         errors.New("some error"),
+			expected:    "",
+		},
+		{
+			description: "single code",
+			err:         errors.New("CKCP017 Failed to unmarshal attributes: some error"),
+			expected:    "CKCP017",
+		},
+		{
+			description: "most specific of wrapped codes",
+			err:         fmt.Errorf("CKCP016 Failed to get Conjur secrets: %w", errors.New("CKCP031 Failed to retrieve secrets: Forbidden")),
+			expected:    "CKCP031",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if actual := ErrorCode(tc.err); actual != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestResult(t *testing.T) {
+	if actual := Result(nil); actual != ResultSuccess {
+		t.Errorf("Expected %q, got %q", ResultSuccess, actual)
+	}
+	if actual := Result(errors.New("some error")); actual != ResultError {
+		t.Errorf("Expected %q, got %q", ResultError, actual)
+	}
+}
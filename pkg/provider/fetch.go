@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// FetchOptions describe a mount performed by Fetch.
+type FetchOptions struct {
+	// SecretProviderClass is a manifest holding the Conjur SecretProviderClass
+	// to mount.
+	SecretProviderClass Manifest
+	// Pod is an optional manifest holding the pod, or workload, whose
+	// annotations list the secrets to mount.
+	Pod *Manifest
+	// Secrets, when set, replaces the pod's conjur.org/secrets annotation.
+	Secrets string
+	// Token is the JWT used to authenticate with Conjur.
+	Token string
+	// SSLCertificate, when set, replaces the certificate given by the
+	// SecretProviderClass. It's required when the SecretProviderClass refers
+	// to a ConfigMap or Secret, since they can't be read outside of a cluster.
+	SSLCertificate string
+}
+
+// Fetch performs a single mount outside of a cluster, retrieving secrets from
+// Conjur just as a mount request from the CSI driver would, to help
+// troubleshoot SecretProviderClasses and pod annotations.
+func Fetch(ctx context.Context, opts FetchOptions) (*v1alpha1.MountResponse, error) {
+	return fetchWithDeps(ctx, opts, conjur.NewClient)
+}
+
+func fetchWithDeps(ctx context.Context, opts FetchOptions, conjurFactory conjur.ClientFactory) (*v1alpha1.MountResponse, error) {
+	objects, err := decodeManifest(opts.SecretProviderClass)
+	if err != nil {
+		return nil, err
+	}
+	var class *object
+	for _, obj := range objects {
+		if obj.Kind == "SecretProviderClass" && obj.Spec.Provider == providerName {
+			class = obj
+			break
+		}
+	}
+	if class == nil {
+		return nil, logmessages.Errorf(logmessages.CKCP071, opts.SecretProviderClass.Name)
+	}
+
+	podName := ""
+	namespace := class.Metadata.Namespace
+	annotations := map[string]string{}
+	if opts.Pod != nil {
+		objects, err := decodeManifest(*opts.Pod)
+		if err != nil {
+			return nil, err
+		}
+		var pod *podTemplate
+		for _, obj := range objects {
+			if pod = obj.podTemplate(); pod != nil {
+				podName = obj.Metadata.Name
+				if obj.Metadata.Namespace != "" {
+					namespace = obj.Metadata.Namespace
+				}
+				break
+			}
+		}
+		if pod == nil {
+			return nil, logmessages.Errorf(logmessages.CKCP072, opts.Pod.Name)
+		}
+		for key, value := range pod.Metadata.Annotations {
+			annotations[key] = value
+		}
+	}
+	if opts.Secrets != "" {
+		annotations[secretsAnnotationKey] = opts.Secrets
+	}
+
+	if opts.SSLCertificate != "" {
+		if class.Spec.Parameters == nil {
+			class.Spec.Parameters = map[string]string{}
+		}
+		delete(class.Spec.Parameters, sslCertificateConfigMapRefKey)
+		delete(class.Spec.Parameters, sslCertificateSecretRefKey)
+		class.Spec.Parameters[sslCertificateKey] = opts.SSLCertificate
+	}
+
+	resp, err := mountWithDeps(
+		ctx,
+		newMountRequest(class, podName, namespace, opts.Token, defaultPermission),
+		conjurFactory,
+		func(string, string, string) (map[string]string, error) {
+			return annotations, nil
+		},
+		func(namespace string, kind string, name string, key string) (string, error) {
+			return "", logmessages.Errorf(logmessages.CKCP073, kind, name)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the versions of secrets are of interest outside of a cluster
+	secretVersions := []*v1alpha1.ObjectVersion{}
+	for _, ov := range resp.GetObjectVersion() {
+		if ov.GetId() != fileSpecObjectID {
+			secretVersions = append(secretVersions, ov)
+		}
+	}
+	resp.ObjectVersion = secretVersions
+	return resp, nil
+}
+
+// WriteFiles writes the files of a mount response into a directory, as the
+// CSI driver would write them into a pod's volume.
+func WriteFiles(dir string, files []*v1alpha1.File) error {
+	for _, file := range files {
+		if !filepath.IsLocal(file.GetPath()) {
+			return logmessages.Errorf(logmessages.CKCP074, file.GetPath())
+		}
+		path := filepath.Join(dir, file.GetPath())
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = os.WriteFile(path, file.GetContents(), os.FileMode(file.GetMode()))
+		}
+		if err == nil {
+			// The mode of an existing file isn't changed by WriteFile
+			err = os.Chmod(path, os.FileMode(file.GetMode()))
+		}
+		if err != nil {
+			return logmessages.Errorf(logmessages.CKCP075, file.GetPath(), err)
+		}
+	}
+	return nil
+}
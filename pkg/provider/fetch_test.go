@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+func TestFetch(t *testing.T) {
+	testCases := []struct {
+		description string
+		opts        FetchOptions
+		assertions  func(*testing.T, *v1alpha1.MountResponse, error, map[string]string)
+	}{
+		{
+			description: "secrets from pod annotations",
+			opts: FetchOptions{
+				SecretProviderClass: Manifest{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+				Pod:                 &Manifest{Name: "deployment.yaml", Data: []byte(validateDeployment)},
+				Token:               "sometoken",
+				SSLCertificate:      "certificate content",
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, attributes map[string]string) {
+				assert.Nil(t, err)
+				assert.Equal(t, []*v1alpha1.File{
+					{Path: "relative/path/fileA.txt", Mode: 0644, Contents: []byte("secretA")},
+				}, resp.GetFiles())
+				assert.Equal(t, []*v1alpha1.ObjectVersion{
+					{Id: "path/to/secret/A", Version: "1"},
+				}, resp.GetObjectVersion())
+				assert.Equal(t, map[string]string{
+					"applianceUrl":   "https://conjur.conjur-ns.svc.cluster.local",
+					"authnId":        "authn-jwt/kube",
+					"account":        "default",
+					"sslCertificate": "certificate content",
+				}, attributes)
+			},
+		},
+		{
+			description: "secrets given directly",
+			opts: FetchOptions{
+				SecretProviderClass: Manifest{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+				Secrets:             "- \"other/fileA.txt\": \"path/to/secret/A\"\n",
+				Token:               "sometoken",
+				SSLCertificate:      "certificate content",
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, attributes map[string]string) {
+				assert.Nil(t, err)
+				assert.Len(t, resp.GetFiles(), 1)
+				assert.Equal(t, "other/fileA.txt", resp.GetFiles()[0].GetPath())
+			},
+		},
+		{
+			description: "certificate reference without certificate",
+			opts: FetchOptions{
+				SecretProviderClass: Manifest{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+				Pod:                 &Manifest{Name: "deployment.yaml", Data: []byte(validateDeployment)},
+				Token:               "sometoken",
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, attributes map[string]string) {
+				assert.ErrorContains(t, err, `CKCP073 Can't read ConfigMap "conjur-ca" from outside of a cluster`)
+			},
+		},
+		{
+			description: "no Conjur SecretProviderClass",
+			opts: FetchOptions{
+				SecretProviderClass: Manifest{Name: "deployment.yaml", Data: []byte(validateDeployment)},
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, attributes map[string]string) {
+				assert.EqualError(t, err, `CKCP071 No SecretProviderClass using the Conjur provider found in manifest "deployment.yaml"`)
+			},
+		},
+		{
+			description: "no pod",
+			opts: FetchOptions{
+				SecretProviderClass: Manifest{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+				Pod:                 &Manifest{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, attributes map[string]string) {
+				assert.EqualError(t, err, `CKCP072 No pod or workload found in manifest "spc.yaml"`)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var attributes map[string]string
+			conjurFactory := func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				attributes = map[string]string{
+					"applianceUrl":   baseURL,
+					"authnId":        authnID,
+					"account":        account,
+					"sslCertificate": sslCert,
+				}
+				return &mockConjurClient{resp: map[string]conjur.Secret{"path/to/secret/A": {Value: []byte("secretA"), Version: "1"}}}
+			}
+
+			resp, err := fetchWithDeps(context.Background(), tc.opts, conjurFactory)
+			tc.assertions(t, resp, err, attributes)
+		})
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	err := WriteFiles(dir, []*v1alpha1.File{
+		{Path: "fileA.txt", Mode: 0600, Contents: []byte("secretA")},
+		{Path: "nested/fileB.txt", Mode: 0644, Contents: []byte("secretB")},
+	})
+	assert.Nil(t, err)
+
+	contents, err := os.ReadFile(filepath.Join(dir, "nested", "fileB.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "secretB", string(contents))
+	info, err := os.Stat(filepath.Join(dir, "fileA.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	err = WriteFiles(dir, []*v1alpha1.File{{Path: "../escaped.txt", Mode: 0644}})
+	assert.EqualError(t, err, `CKCP074 Refusing to write file "../escaped.txt" outside of the output directory`)
+}
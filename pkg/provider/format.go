@@ -0,0 +1,391 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode/utf16"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	fileFormatYAML       = "yaml"
+	fileFormatJSON       = "json"
+	fileFormatDotenv     = "dotenv"
+	fileFormatBash       = "bash"
+	fileFormatProperties = "properties"
+	fileFormatPlain      = "plain"
+	fileFormatTemplate   = "template"
+)
+
+// fileFormatExtensions maps each supported secret file format to the file
+// extension used when a secret group doesn't specify its own file path.
+var fileFormatExtensions = map[string]string{
+	fileFormatYAML:       "yaml",
+	fileFormatJSON:       "json",
+	fileFormatDotenv:     "env",
+	fileFormatBash:       "sh",
+	fileFormatProperties: "properties",
+	fileFormatPlain:      "txt",
+	fileFormatTemplate:   "txt",
+}
+
+var shellVariablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// maxTemplateOutput limits the size of a file rendered from a template, which
+// could otherwise be made to exhaust the provider's memory, since templates
+// are given by pod annotations. It matches the response size at which the
+// CSI driver starts warning.
+const maxTemplateOutput = 1 << 20
+
+// limitedBuffer is a buffer which fails writes taking it beyond its limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("rendered file exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// secretFile describes a single file written to the mount, and the Conjur
+// secrets rendered into it.
+type secretFile struct {
+	// File path relative to the mount
+	path string
+	// Output format used to render the file contents
+	format string
+	// Secrets rendered into the file, in the order they were declared
+	secrets []secretRef
+	// Template used to render the file when using the template format
+	template *template.Template
+}
+
+// secretRef relates a Conjur variable ID to the alias used to refer to it
+// within a rendered file.
+type secretRef struct {
+	alias string
+	id    string
+}
+
+// validate ensures the file's secrets can be represented in its format.
+func (f *secretFile) validate() error {
+	if _, ok := fileFormatExtensions[f.format]; !ok {
+		return fmt.Errorf("unsupported format %q", f.format)
+	}
+
+	if (f.format == fileFormatTemplate) != (f.template != nil) {
+		return fmt.Errorf("a template must be provided with, and only with, format %q", fileFormatTemplate)
+	}
+
+	if f.format == fileFormatPlain && len(f.secrets) != 1 {
+		return fmt.Errorf("format %q requires exactly one secret, found %d", f.format, len(f.secrets))
+	}
+
+	aliases := map[string]bool{}
+	for _, s := range f.secrets {
+		if _, ok := conjur.PolicyBranch(s.id); ok {
+			return fmt.Errorf("policy branch %q can't be rendered into a single file", s.id)
+		}
+		if aliases[s.alias] {
+			return fmt.Errorf("duplicate alias %q", s.alias)
+		}
+		aliases[s.alias] = true
+
+		if (f.format == fileFormatDotenv || f.format == fileFormatBash) &&
+			!shellVariablePattern.MatchString(s.alias) {
+			return fmt.Errorf("alias %q is not a valid variable name for format %q", s.alias, f.format)
+		}
+	}
+
+	return nil
+}
+
+// policyBranch returns the prefix of the variables under the policy branch
+// referenced by the file, if it maps a directory to a policy branch rather
+// than describing a single file.
+func (f *secretFile) policyBranch() (string, bool) {
+	if len(f.secrets) != 1 {
+		return "", false
+	}
+	return conjur.PolicyBranch(f.secrets[0].id)
+}
+
+// expandPolicyBranch returns a plain file for each retrieved secret under the
+// policy branch referenced by the file, named after the secret's ID relative
+// to the branch and placed under the file's path. Files that don't reference
+// a policy branch are returned as-is.
+func (f *secretFile) expandPolicyBranch(secrets map[string]conjur.Secret) []*secretFile {
+	branch, ok := f.policyBranch()
+	if !ok {
+		return []*secretFile{f}
+	}
+
+	ids := []string{}
+	for id := range secrets {
+		if strings.HasPrefix(id, branch) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	files := make([]*secretFile, 0, len(ids))
+	for _, id := range ids {
+		relativeID := strings.TrimPrefix(id, branch)
+		files = append(files, &secretFile{
+			path:    path.Join(f.path, relativeID),
+			format:  fileFormatPlain,
+			secrets: []secretRef{{alias: path.Base(relativeID), id: id}},
+		})
+	}
+	return files
+}
+
+// render produces the contents of the file given the retrieved secrets.
+func (f *secretFile) render(secrets map[string]conjur.Secret) ([]byte, error) {
+	for _, s := range f.secrets {
+		if _, ok := secrets[s.id]; !ok {
+			return nil, fmt.Errorf("secret %q was not retrieved", s.id)
+		}
+	}
+
+	switch f.format {
+	case fileFormatPlain:
+		return secrets[f.secrets[0].id].Value, nil
+	case fileFormatTemplate:
+		return f.renderTemplate(secrets)
+	case fileFormatYAML:
+		return f.renderYAML(secrets)
+	case fileFormatJSON:
+		return f.renderJSON(secrets)
+	case fileFormatDotenv:
+		return f.renderLines(secrets, func(alias, value string) string {
+			return fmt.Sprintf("%s=%s", alias, doubleQuote(value))
+		}), nil
+	case fileFormatBash:
+		return f.renderLines(secrets, func(alias, value string) string {
+			return fmt.Sprintf("export %s=%s", alias, singleQuote(value))
+		}), nil
+	case fileFormatProperties:
+		return f.renderLines(secrets, func(alias, value string) string {
+			return fmt.Sprintf("%s=%s", escapeProperty(alias, true), escapeProperty(value, false))
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f.format)
+	}
+}
+
+// renderYAML builds a YAML mapping node by hand so that keys keep their
+// declared order rather than being sorted.
+func (f *secretFile) renderYAML(secrets map[string]conjur.Secret) ([]byte, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, s := range f.secrets {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s.alias},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(secrets[s.id].Value)},
+		)
+	}
+	return yaml.Marshal(node)
+}
+
+// renderJSON writes a JSON object one member at a time so that keys keep their
+// declared order rather than being sorted.
+func (f *secretFile) renderJSON(secrets map[string]conjur.Secret) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, s := range f.secrets {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(s.alias)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(string(secrets[s.id].Value))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes(), nil
+}
+
+// renderTemplate executes the file's template with template functions bound
+// to the file's secrets.
+func (f *secretFile) renderTemplate(secrets map[string]conjur.Secret) ([]byte, error) {
+	values := map[string]string{}
+	for _, s := range f.secrets {
+		values[s.id] = string(secrets[s.id].Value)
+	}
+	// Aliases take precedence over IDs, and are added last to overwrite them
+	for _, s := range f.secrets {
+		values[s.alias] = string(secrets[s.id].Value)
+	}
+
+	buf := &limitedBuffer{limit: maxTemplateOutput}
+	err := f.template.Funcs(templateFuncs(values)).Execute(buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *secretFile) renderLines(secrets map[string]conjur.Secret, line func(alias, value string) string) []byte {
+	var buf bytes.Buffer
+	for _, s := range f.secrets {
+		buf.WriteString(line(s.alias, string(secrets[s.id].Value)))
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// parseTemplate parses a secret file template, making only a restricted set of
+// functions available to it. The functions are bound to secret values when
+// the template is rendered. Since templates are given by pod annotations,
+// loops and nested templates are rejected, so that rendering a template
+// always takes time proportional to its length.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs(nil)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("template: %s: define and block actions aren't supported", name)
+	}
+	if tmpl.Tree != nil {
+		if err := checkTemplateNode(name, tmpl.Tree.Root); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// checkTemplateNode returns an error if a parsed template node contains a
+// range or template action.
+func checkTemplateNode(name string, node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(name, child); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return checkTemplateBranch(name, &n.BranchNode)
+	case *parse.WithNode:
+		return checkTemplateBranch(name, &n.BranchNode)
+	case *parse.RangeNode:
+		return fmt.Errorf("template: %s: range actions aren't supported", name)
+	case *parse.TemplateNode:
+		return fmt.Errorf("template: %s: template actions aren't supported", name)
+	}
+	return nil
+}
+
+func checkTemplateBranch(name string, branch *parse.BranchNode) error {
+	if err := checkTemplateNode(name, branch.List); err != nil {
+		return err
+	}
+	return checkTemplateNode(name, branch.ElseList)
+}
+
+// templateFuncs returns the functions available to secret file templates.
+// The 'secret' function looks up a secret value by alias or by Conjur
+// variable ID.
+func templateFuncs(values map[string]string) template.FuncMap {
+	return template.FuncMap{
+		"secret": func(name string) (string, error) {
+			value, ok := values[name]
+			if !ok {
+				return "", fmt.Errorf("secret %q is not defined in the secret group", name)
+			}
+			return value, nil
+		},
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			return string(decoded), err
+		},
+		"trim": strings.TrimSpace,
+		"toJson": func(v any) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+	}
+}
+
+// doubleQuote quotes a value for dotenv files, escaping characters that are
+// interpreted within double quotes.
+func doubleQuote(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		"`", "\\`",
+		"\n", `\n`,
+	)
+	return `"` + r.Replace(s) + `"`
+}
+
+// singleQuote quotes a value for bash, where nothing within single quotes is
+// interpreted. Embedded single quotes close the string, add an escaped quote,
+// and reopen it.
+func singleQuote(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `'\''`) + `'`
+}
+
+// escapeProperty escapes a key or value as described by the
+// java.util.Properties file format. Non-ASCII characters are written as
+// Unicode escapes since properties files are read as ISO-8859-1 by default.
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '=' || r == ':' || r == '#' || r == '!' || r == ' ':
+			// Leading whitespace is trimmed from values, and the remaining
+			// characters only have meaning within keys
+			if isKey || (i == 0 && r == ' ') {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+		case r < 0x20 || r > 0x7e:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,328 @@
+package provider
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretFileValidate(t *testing.T) {
+	testCases := []struct {
+		description string
+		file        *secretFile
+		assertions  func(*testing.T, error)
+	}{
+		{
+			description: "unsupported format",
+			file: &secretFile{
+				format:  "xml",
+				secrets: []secretRef{{alias: "a", id: "path/a"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), `unsupported format "xml"`)
+			},
+		},
+		{
+			description: "plain format with multiple secrets",
+			file: &secretFile{
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: "a", id: "path/a"}, {alias: "b", id: "path/b"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), "requires exactly one secret, found 2")
+			},
+		},
+		{
+			description: "duplicate alias",
+			file: &secretFile{
+				format:  fileFormatJSON,
+				secrets: []secretRef{{alias: "a", id: "path/a"}, {alias: "a", id: "other/a"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), `duplicate alias "a"`)
+			},
+		},
+		{
+			description: "invalid variable name for bash",
+			file: &secretFile{
+				format:  fileFormatBash,
+				secrets: []secretRef{{alias: "db-password", id: "db/db-password"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), `alias "db-password" is not a valid variable name`)
+			},
+		},
+		{
+			description: "non-identifier alias is valid for yaml",
+			file: &secretFile{
+				format:  fileFormatYAML,
+				secrets: []secretRef{{alias: "db-password", id: "db/db-password"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			description: "template format without a template",
+			file: &secretFile{
+				format:  fileFormatTemplate,
+				secrets: []secretRef{{alias: "a", id: "path/a"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), `a template must be provided with, and only with, format "template"`)
+			},
+		},
+		{
+			description: "policy branch in secret group",
+			file: &secretFile{
+				format:  fileFormatYAML,
+				secrets: []secretRef{{alias: "*", id: "db/*"}},
+			},
+			assertions: func(t *testing.T, err error) {
+				assert.Contains(t, err.Error(), `policy branch "db/*" can't be rendered into a single file`)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.assertions(t, tc.file.validate())
+		})
+	}
+}
+
+func TestSecretFileRender(t *testing.T) {
+	secrets := map[string]conjur.Secret{
+		"db/user":     {Value: []byte("admin"), Version: "1"},
+		"db/password": {Value: []byte(`p@ss'wo"rd $x`), Version: "1"},
+		"db/cert":     {Value: []byte("line1\nline2"), Version: "1"},
+		"db/unicode":  {Value: []byte("café"), Version: "1"},
+		"db/large":    {Value: bytes.Repeat([]byte("a"), 600*1024), Version: "1"},
+	}
+
+	testCases := []struct {
+		description string
+		file        *secretFile
+		expected    string
+		expectedErr string
+	}{
+		{
+			description: "plain",
+			file: &secretFile{
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: "cert", id: "db/cert"}},
+			},
+			expected: "line1\nline2",
+		},
+		{
+			description: "yaml keeps declared order",
+			file: &secretFile{
+				format:  fileFormatYAML,
+				secrets: []secretRef{{alias: "user", id: "db/user"}, {alias: "cert", id: "db/cert"}},
+			},
+			expected: "user: admin\ncert: |-\n    line1\n    line2\n",
+		},
+		{
+			description: "json keeps declared order",
+			file: &secretFile{
+				format:  fileFormatJSON,
+				secrets: []secretRef{{alias: "user", id: "db/user"}, {alias: "password", id: "db/password"}},
+			},
+			expected: `{"user":"admin","password":"p@ss'wo\"rd $x"}` + "\n",
+		},
+		{
+			description: "dotenv",
+			file: &secretFile{
+				format:  fileFormatDotenv,
+				secrets: []secretRef{{alias: "PASSWORD", id: "db/password"}, {alias: "CERT", id: "db/cert"}},
+			},
+			expected: "PASSWORD=\"p@ss'wo\\\"rd \\$x\"\nCERT=\"line1\\nline2\"\n",
+		},
+		{
+			description: "bash",
+			file: &secretFile{
+				format:  fileFormatBash,
+				secrets: []secretRef{{alias: "PASSWORD", id: "db/password"}},
+			},
+			expected: "export PASSWORD='p@ss'\\''wo\"rd $x'\n",
+		},
+		{
+			description: "properties",
+			file: &secretFile{
+				format:  fileFormatProperties,
+				secrets: []secretRef{{alias: "db.user", id: "db/user"}, {alias: "cert", id: "db/cert"}, {alias: "name", id: "db/unicode"}},
+			},
+			expected: "db.user=admin\ncert=line1\\nline2\nname=caf\\u00e9\n",
+		},
+		{
+			description: "missing secret",
+			file: &secretFile{
+				format:  fileFormatJSON,
+				secrets: []secretRef{{alias: "user", id: "db/missing"}},
+			},
+			expectedErr: `secret "db/missing" was not retrieved`,
+		},
+		{
+			description: "template",
+			file: &secretFile{
+				format:   fileFormatTemplate,
+				secrets:  []secretRef{{alias: "user", id: "db/user"}, {alias: "password", id: "db/password"}},
+				template: mustParseTemplate(t, `postgres://{{ secret "db/user" }}:{{ secret "password" | b64enc }}@db`),
+			},
+			expected: "postgres://admin:cEBzcyd3byJyZCAkeA==@db",
+		},
+		{
+			description: "template functions",
+			file: &secretFile{
+				format:   fileFormatTemplate,
+				secrets:  []secretRef{{alias: "password", id: "db/password"}},
+				template: mustParseTemplate(t, `{{ secret "password" | toJson }} {{ "  x  " | trim }} {{ "YWRtaW4=" | b64dec }}`),
+			},
+			expected: `"p@ss'wo\"rd $x" x admin`,
+		},
+		{
+			description: "template references secret outside of group",
+			file: &secretFile{
+				format:   fileFormatTemplate,
+				secrets:  []secretRef{{alias: "user", id: "db/user"}},
+				template: mustParseTemplate(t, `{{ secret "db/password" }}`),
+			},
+			expectedErr: `secret "db/password" is not defined in the secret group`,
+		},
+		{
+			description: "template output too large",
+			file: &secretFile{
+				format:   fileFormatTemplate,
+				secrets:  []secretRef{{alias: "large", id: "db/large"}},
+				template: mustParseTemplate(t, `{{ secret "large" }}{{ secret "large" }}`),
+			},
+			expectedErr: "rendered file exceeds 1048576 bytes",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			contents, err := tc.file.render(secrets)
+			if tc.expectedErr != "" {
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, string(contents))
+		})
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	testCases := []struct {
+		description string
+		text        string
+		expectedErr string
+	}{
+		{
+			description: "conditionals and pipelines",
+			text:        `{{ if secret "user" }}{{ with secret "pass" }}{{ . | b64enc }}{{ end }}{{ else }}none{{ end }}`,
+		},
+		{
+			description: "range",
+			text:        `{{ range 100000000000 }}{{ end }}`,
+			expectedErr: "template: test: range actions aren't supported",
+		},
+		{
+			description: "range nested in a conditional",
+			text:        `{{ if true }}{{ else }}{{ range 10 }}x{{ end }}{{ end }}`,
+			expectedErr: "template: test: range actions aren't supported",
+		},
+		{
+			description: "recursive templates",
+			text:        `{{ define "x" }}{{ template "x" }}{{ end }}{{ template "x" }}`,
+			expectedErr: "template: test: define and block actions aren't supported",
+		},
+		{
+			description: "block",
+			text:        `{{ block "x" . }}x{{ end }}`,
+			expectedErr: "template: test: define and block actions aren't supported",
+		},
+		{
+			description: "syntax error",
+			text:        `{{ secret "user" `,
+			expectedErr: "template: test:1: unclosed action",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := parseTemplate("test", tc.text)
+			if tc.expectedErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestSecretFileExpandPolicyBranch(t *testing.T) {
+	secrets := map[string]conjur.Secret{
+		"db/url":             {Value: []byte("url"), Version: "1"},
+		"db/nested/password": {Value: []byte("password"), Version: "1"},
+		"other":              {Value: []byte("other"), Version: "1"},
+	}
+
+	testCases := []struct {
+		description string
+		file        *secretFile
+		expected    []*secretFile
+	}{
+		{
+			description: "file without policy branch is unchanged",
+			file: &secretFile{
+				path:    "other.txt",
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: "other", id: "other"}},
+			},
+			expected: []*secretFile{{
+				path:    "other.txt",
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: "other", id: "other"}},
+			}},
+		},
+		{
+			description: "policy branch is expanded to files named by relative ID",
+			file: &secretFile{
+				path:    "config/db",
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: "*", id: "db/*"}},
+			},
+			expected: []*secretFile{
+				{
+					path:    "config/db/nested/password",
+					format:  fileFormatPlain,
+					secrets: []secretRef{{alias: "password", id: "db/nested/password"}},
+				},
+				{
+					path:    "config/db/url",
+					format:  fileFormatPlain,
+					secrets: []secretRef{{alias: "url", id: "db/url"}},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.file.expandPolicyBranch(secrets))
+		})
+	}
+}
+
+func mustParseTemplate(t *testing.T, text string) *template.Template {
+	tmpl, err := parseTemplate("test", text)
+	if err != nil {
+		t.Fatalf("failed to parse template: %v", err)
+	}
+	return tmpl
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// healthWatchInterval is how often the serving status is checked for changes
+// to send to Watch requests.
+const healthWatchInterval = 5 * time.Second
+
+// grpcHealthServer implements the standard gRPC health service on the
+// provider's socket, so that the socket the CSI driver uses can be probed
+// directly, for example with grpc_health_probe. Both the overall health of
+// the server and that of the CSI provider service can be checked.
+type grpcHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	provider *ConjurProviderServer
+}
+
+// servingStatus reports the provider serving unless its socket isn't being
+// served, it's shutting down, or none of the Conjur appliances used to serve
+// recent mount requests are reachable, as for the /readyz endpoint.
+func (h *grpcHealthServer) servingStatus(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	if service != "" && service != v1alpha1.CSIDriverProvider_ServiceDesc.ServiceName {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+
+	if !h.provider.serving.Load() || h.provider.Draining() ||
+		!appliancesReachable(h.provider.checkAppliances(ctx)) {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+func (h *grpcHealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	servingStatus, err := h.servingStatus(ctx, req.GetService())
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+// Watch sends the serving status of a service, followed by every change to it
+// until the request is cancelled. Unknown services are reported as
+// SERVICE_UNKNOWN rather than failing, as the health service requires. Once
+// the provider starts shutting down, the final NOT_SERVING status is sent and
+// the stream ends, so that it doesn't hold up the provider's drain.
+func (h *grpcHealthServer) Watch(req *healthpb.HealthCheckRequest, stream grpc.ServerStreamingServer[healthpb.HealthCheckResponse]) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		servingStatus, _ := h.servingStatus(stream.Context(), req.GetService())
+		if servingStatus != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			last = servingStatus
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-h.provider.drained:
+			return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING})
+		case <-ticker.C:
+		}
+	}
+}
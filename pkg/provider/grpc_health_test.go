@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	stdlog "log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCHealthCheck(t *testing.T) {
+	testCases := []struct {
+		description string
+		service     string
+		serving     bool
+		draining    bool
+		appliances  []conjur.ApplianceStatus
+		assertions  func(*testing.T, *healthpb.HealthCheckResponse, error)
+	}{
+		{
+			description: "serving",
+			serving:     true,
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+			},
+		},
+		{
+			description: "serving the CSI provider service",
+			service:     "v1alpha1.CSIDriverProvider",
+			serving:     true,
+			appliances:  []conjur.ApplianceStatus{{URL: "https://a", Healthy: true}},
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+			},
+		},
+		{
+			description: "not started",
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+			},
+		},
+		{
+			description: "draining",
+			serving:     true,
+			draining:    true,
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+			},
+		},
+		{
+			description: "every appliance unreachable",
+			serving:     true,
+			appliances:  []conjur.ApplianceStatus{{URL: "https://a", Healthy: false}},
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+			},
+		},
+		{
+			description: "unknown service",
+			service:     "other.Service",
+			serving:     true,
+			assertions: func(t *testing.T, resp *healthpb.HealthCheckResponse, err error) {
+				assert.Equal(t, codes.NotFound, status.Code(err))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			p := &ConjurProviderServer{
+				checkAppliances: func(context.Context) []conjur.ApplianceStatus { return tc.appliances },
+			}
+			p.serving.Store(tc.serving)
+			p.draining.Store(tc.draining)
+
+			h := &grpcHealthServer{provider: p}
+			resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tc.service})
+			tc.assertions(t, resp, err)
+		})
+	}
+}
+
+func TestGRPCHealthOnSocket(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	p := newServerWithDeps(
+		DefaultSocketPath,
+		ServerOptions{},
+		func(opt ...grpc.ServerOption) grpcServer { return grpc.NewServer(opt...) },
+		nil,
+		Version,
+	)
+	p.checkAppliances = func(context.Context) []conjur.ApplianceStatus { return nil }
+	go p.startWithDeps(func(string, string) (net.Listener, error) { return listener, nil }, "")
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	stream, err := client.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	resp, err := stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// Open watches end once the provider drains, rather than holding up its stop
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.Stop(ctx)
+	assert.Nil(t, ctx.Err())
+
+	resp, err = stream.Recv()
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestGRPCHealthWatchRecoversFromPanics(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.ErrorLogger = stdlog.New(&logBuffer, "", 0)
+
+	listener := bufconn.Listen(1 << 20)
+	p := newServerWithDeps(
+		DefaultSocketPath,
+		ServerOptions{},
+		func(opt ...grpc.ServerOption) grpcServer { return grpc.NewServer(opt...) },
+		nil,
+		Version,
+	)
+	p.checkAppliances = func(context.Context) []conjur.ApplianceStatus { panic("nil map") }
+	go p.startWithDeps(func(string, string) (net.Listener, error) { return listener, nil }, "")
+	defer p.Stop(context.Background())
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Eventually(t, p.serving.Load, time.Second, 10*time.Millisecond)
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.Nil(t, err)
+	_, err = stream.Recv()
+
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, s.Code())
+	assert.Equal(t, "CKCP082 Recovered from panic while serving Watch: nil map", s.Message())
+	assert.Contains(t, logBuffer.String(), "CKCP082 Recovered from panic while serving Watch: nil map\ngoroutine")
+}
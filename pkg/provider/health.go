@@ -2,11 +2,15 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -14,6 +18,8 @@ const DefaultPort int = 8080
 
 // HealthServer is responsible for serving a /healthz endpoint over HTTP on a
 // given port in order to report on the health of a ConjurProviderServer instance.
+// It also serves a /readyz endpoint reporting whether recently used Conjur
+// appliances are reachable, and the provider's Prometheus metrics on /metrics.
 type HealthServer struct {
 	port     int
 	provider *ConjurProviderServer
@@ -27,6 +33,7 @@ func NewHealthServer(provider *ConjurProviderServer, port int) *HealthServer {
 		provider,
 		port,
 		defaultHealthCheckFactory,
+		conjur.CheckAppliances,
 	)
 }
 
@@ -34,9 +41,12 @@ func newHealthServerWithDeps(
 	provider *ConjurProviderServer,
 	port int,
 	healthCheckFactory func(*ConjurProviderServer) func(http.ResponseWriter, *http.Request),
+	checkAppliances func(context.Context) []conjur.ApplianceStatus,
 ) *HealthServer {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", healthCheckFactory(provider))
+	mux.HandleFunc("/readyz", readinessCheck(provider, checkAppliances))
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
 
 	return &HealthServer{
 		port:     port,
@@ -54,11 +64,12 @@ func (h *HealthServer) Start() error {
 	return h.server.ListenAndServe()
 }
 
-// Stop gracefully shuts down the HeathServer's HTTP server.
-func (h *HealthServer) Stop() error {
+// Stop gracefully shuts down the HeathServer's HTTP server, waiting for
+// in-flight requests to complete until ctx is done.
+func (h *HealthServer) Stop(ctx context.Context) error {
 	log.Info(logmessages.CKCP025)
 
-	err := h.server.Shutdown(context.TODO())
+	err := h.server.Shutdown(ctx)
 	if err == nil {
 		log.Info(logmessages.CKCP026)
 	}
@@ -78,3 +89,56 @@ func defaultHealthCheckFactory(provider *ConjurProviderServer) func(http.Respons
 		}
 	}
 }
+
+// readinessResponse is the body of the /readyz endpoint.
+type readinessResponse struct {
+	Ready      bool                     `json:"ready"`
+	Draining   bool                     `json:"draining,omitempty"`
+	Appliances []conjur.ApplianceStatus `json:"appliances"`
+}
+
+// readinessCheck reports the provider ready when it is serving and can reach
+// at least one of the Conjur appliances used to serve recent mount requests.
+// Until a mount request has been served there are no appliances to check, and
+// the provider is reported ready as long as it is serving. Once the provider
+// starts shutting down it's never reported ready.
+func readinessCheck(
+	provider *ConjurProviderServer,
+	checkAppliances func(context.Context) []conjur.ApplianceStatus,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		_, err := provider.versionFunc(req.Context(), &v1alpha1.VersionRequest{
+			Version: "ready",
+		})
+
+		appliances := checkAppliances(req.Context())
+		reachable := appliancesReachable(appliances)
+
+		draining := provider.Draining()
+		resp := readinessResponse{
+			Ready:      err == nil && reachable && !draining,
+			Draining:   draining,
+			Appliances: appliances,
+		}
+		body, _ := json.Marshal(resp)
+
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(body)
+	}
+}
+
+// appliancesReachable reports whether at least one of the Conjur appliances
+// used to serve recent mount requests is reachable, or none have been used.
+func appliancesReachable(appliances []conjur.ApplianceStatus) bool {
+	for _, appliance := range appliances {
+		if appliance.Healthy {
+			return true
+		}
+	}
+	return len(appliances) == 0
+}
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
 	"github.com/stretchr/testify/assert"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
@@ -86,6 +89,7 @@ func TestNewHealthServer(t *testing.T) {
 				p,
 				DefaultPort+i,
 				defaultHealthCheckFactory,
+				nil,
 			)
 			go func() {
 				h.Start()
@@ -102,8 +106,105 @@ func TestNewHealthServer(t *testing.T) {
 
 			tc.assertions(t, v, w)
 
-			err = h.Stop()
+			err = h.Stop(context.Background())
 			assert.Nil(t, err)
 		})
 	}
 }
+
+func TestHealthServerMetrics(t *testing.T) {
+	h := newHealthServerWithDeps(
+		&ConjurProviderServer{},
+		DefaultPort,
+		defaultHealthCheckFactory,
+		nil,
+	)
+
+	req, err := http.NewRequest("GET", "/metrics", strings.NewReader(""))
+	assert.Nil(t, err)
+	metrics.MountRequests.WithLabelValues("health-namespace", metrics.ResultSuccess, "", "").Inc()
+	w := httptest.NewRecorder()
+	h.server.Handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `conjur_csi_provider_mount_requests_total{category="",code="",namespace="health-namespace",result="success"} 1`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestReadinessCheck(t *testing.T) {
+	testCases := []struct {
+		description string
+		versionErr  error
+		draining    bool
+		appliances  []conjur.ApplianceStatus
+		assertions  func(*testing.T, *httptest.ResponseRecorder)
+	}{
+		{
+			description: "ready before any appliance is used",
+			appliances:  []conjur.ApplianceStatus{},
+			assertions: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Equal(t, 200, w.Code)
+				assert.JSONEq(t, `{"ready":true,"appliances":[]}`, w.Body.String())
+			},
+		},
+		{
+			description: "ready when any appliance is healthy",
+			appliances: []conjur.ApplianceStatus{
+				{URL: "https://a", Healthy: false, Error: "connection refused"},
+				{URL: "https://b", Healthy: true},
+			},
+			assertions: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Equal(t, 200, w.Code)
+				assert.Contains(t, w.Body.String(), `"url":"https://a","healthy":false,"error":"connection refused"`)
+			},
+		},
+		{
+			description: "not ready when every appliance is unhealthy",
+			appliances: []conjur.ApplianceStatus{
+				{URL: "https://a", Healthy: false, Error: "connection refused"},
+			},
+			assertions: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Equal(t, 503, w.Code)
+				assert.Contains(t, w.Body.String(), `"ready":false`)
+			},
+		},
+		{
+			description: "not ready when provider not serving",
+			versionErr:  errors.New("some error"),
+			appliances:  []conjur.ApplianceStatus{},
+			assertions: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Equal(t, 503, w.Code)
+			},
+		},
+		{
+			description: "not ready when provider draining",
+			draining:    true,
+			appliances:  []conjur.ApplianceStatus{{URL: "https://a", Healthy: true}},
+			assertions: func(t *testing.T, w *httptest.ResponseRecorder) {
+				assert.Equal(t, 503, w.Code)
+				assert.Contains(t, w.Body.String(), `"ready":false,"draining":true`)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			v := &mockVersionResponder{err: tc.versionErr}
+			p := &ConjurProviderServer{versionFunc: v.Version}
+			p.draining.Store(tc.draining)
+			h := newHealthServerWithDeps(
+				p,
+				DefaultPort,
+				defaultHealthCheckFactory,
+				func(context.Context) []conjur.ApplianceStatus { return tc.appliances },
+			)
+
+			req, err := http.NewRequest("GET", "/readyz", strings.NewReader(""))
+			assert.Nil(t, err)
+			w := httptest.NewRecorder()
+			h.server.Handler.ServeHTTP(w, req)
+
+			tc.assertions(t, w)
+		})
+	}
+}
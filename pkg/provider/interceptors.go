@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"context"
+	"path"
+	"runtime/debug"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultRequestTimeout is the deadline given to requests which the CSI
+// driver sends without one.
+const DefaultRequestTimeout = 30 * time.Second
+
+// unaryInterceptors returns the interceptors wrapping every request served by
+// a ConjurProviderServer, outermost first. Requests are logged and measured
+// after any panic has been recovered, so that they're reported as failed.
+func unaryInterceptors(opts ServerOptions) []grpc.UnaryServerInterceptor {
+	return []grpc.UnaryServerInterceptor{
+		loggingInterceptor,
+		metricsInterceptor,
+		recoveryInterceptor,
+		timeoutInterceptor(opts.RequestTimeout),
+	}
+}
+
+// streamInterceptors returns the interceptors wrapping every streaming
+// request, such as the gRPC health service's Watch, outermost first.
+func streamInterceptors() []grpc.StreamServerInterceptor {
+	return []grpc.StreamServerInterceptor{
+		streamRecoveryInterceptor,
+	}
+}
+
+// loggingInterceptor logs the method, duration and status code of each
+// request. Successful requests other than mounts, such as the CSI driver's
+// Version calls and health probes, are frequent and only logged at debug
+// level.
+func loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	method := path.Base(info.FullMethod)
+	if method == "Mount" || err != nil {
+		log.Info(logmessages.CKCP083, method, time.Since(start), status.Code(err))
+	} else {
+		log.Debug(logmessages.CKCP083, method, time.Since(start), status.Code(err))
+	}
+	return resp, err
+}
+
+// metricsInterceptor records the duration of each request.
+func metricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	metrics.GRPCRequestDuration.
+		WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// recoveryInterceptor converts a panic while serving a request into an
+// Internal error, rather than letting it crash the provider and fail every
+// other request on the node.
+func recoveryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			resp = nil
+			err = recoveredError(info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// streamRecoveryInterceptor converts a panic while serving a streaming
+// request into an Internal error, as recoveryInterceptor does for unary
+// requests.
+func streamRecoveryInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoveredError(info.FullMethod, r)
+		}
+	}()
+	return handler(srv, stream)
+}
+
+// recoveredError logs a panic recovered while serving method, along with the
+// stack, and returns the Internal error reported in its place.
+func recoveredError(method string, r any) error {
+	recovered := logmessages.Errorf(logmessages.CKCP082, path.Base(method), r)
+	log.Error("%s\n%s", recovered.Error(), debug.Stack())
+	return status.Error(codes.Internal, recovered.Error())
+}
+
+// timeoutInterceptor returns an interceptor which applies a deadline to
+// requests that don't already have one, so that a hung call to Conjur or
+// Kubernetes can't hold a request open indefinitely. A timeout of zero
+// disables it.
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+			return handler(ctx, req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	stdlog "log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+// serveInterceptedMounts serves mount requests with mountFunc through the
+// interceptors of a real gRPC server, returning a client connected to it.
+func serveInterceptedMounts(
+	t *testing.T,
+	opts ServerOptions,
+	mountFunc func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error),
+) v1alpha1.CSIDriverProviderClient {
+	listener := bufconn.Listen(1 << 20)
+	var server *grpc.Server
+	newServerWithDeps(
+		DefaultSocketPath,
+		opts,
+		func(opt ...grpc.ServerOption) grpcServer {
+			server = grpc.NewServer(opt...)
+			return server
+		},
+		mountFunc,
+		Version,
+	)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return v1alpha1.NewCSIDriverProviderClient(conn)
+}
+
+func TestInterceptors(t *testing.T) {
+	testCases := []struct {
+		description string
+		opts        ServerOptions
+		ctx         func() (context.Context, context.CancelFunc)
+		mountFunc   func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error)
+		assertions  func(*testing.T, error, string)
+	}{
+		{
+			description: "recovers from panics as Internal errors",
+			mountFunc: func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+				panic("nil map")
+			},
+			assertions: func(t *testing.T, err error, logs string) {
+				s, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, codes.Internal, s.Code())
+				assert.Equal(t, "CKCP082 Recovered from panic while serving Mount: nil map", s.Message())
+				assert.Contains(t, logs, "CKCP082 Recovered from panic while serving Mount: nil map\ngoroutine")
+				assert.Contains(t, logs, "CKCP083 Served Mount in")
+				assert.Contains(t, logs, "with code Internal")
+			},
+		},
+		{
+			description: "applies the default timeout to requests without a deadline",
+			opts:        ServerOptions{RequestTimeout: time.Minute},
+			mountFunc: func(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok || time.Until(deadline) > time.Minute {
+					return nil, status.Error(codes.FailedPrecondition, "missing default deadline")
+				}
+				return &v1alpha1.MountResponse{}, nil
+			},
+			assertions: func(t *testing.T, err error, logs string) {
+				assert.Nil(t, err)
+				assert.Contains(t, logs, "with code OK")
+			},
+		},
+		{
+			description: "keeps the deadline sent by the CSI driver",
+			opts:        ServerOptions{RequestTimeout: time.Minute},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Hour)
+			},
+			mountFunc: func(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+				deadline, _ := ctx.Deadline()
+				if time.Until(deadline) < time.Minute {
+					return nil, status.Error(codes.FailedPrecondition, "deadline replaced")
+				}
+				return &v1alpha1.MountResponse{}, nil
+			},
+			assertions: func(t *testing.T, err error, logs string) {
+				assert.Nil(t, err)
+			},
+		},
+		{
+			description: "leaves requests without a deadline when the timeout is zero",
+			mountFunc: func(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+				if _, ok := ctx.Deadline(); ok {
+					return nil, status.Error(codes.FailedPrecondition, "unexpected deadline")
+				}
+				return &v1alpha1.MountResponse{}, nil
+			},
+			assertions: func(t *testing.T, err error, logs string) {
+				assert.Nil(t, err)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var logBuffer bytes.Buffer
+			log.InfoLogger = stdlog.New(&logBuffer, "", 0)
+			log.ErrorLogger = stdlog.New(&logBuffer, "", 0)
+
+			client := serveInterceptedMounts(t, tc.opts, tc.mountFunc)
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tc.ctx != nil {
+				ctx, cancel = tc.ctx()
+			}
+			defer cancel()
+
+			_, err := client.Mount(ctx, &v1alpha1.MountRequest{})
+			tc.assertions(t, err, logBuffer.String())
+		})
+	}
+}
+
+func TestInterceptorLogging(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.InfoLogger = stdlog.New(&logBuffer, "", 0)
+	defer logging.SetLogLevel("info")
+
+	client := serveInterceptedMounts(t, ServerOptions{}, func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+		return &v1alpha1.MountResponse{}, nil
+	})
+
+	_, err := client.Mount(context.Background(), &v1alpha1.MountRequest{})
+	assert.Nil(t, err)
+	_, err = client.Version(context.Background(), &v1alpha1.VersionRequest{})
+	assert.Nil(t, err)
+	assert.Contains(t, logBuffer.String(), "CKCP083 Served Mount in")
+	assert.NotContains(t, logBuffer.String(), "CKCP083 Served Version in")
+
+	// Frequent requests are still logged at debug level
+	logging.SetLogLevel("debug")
+	_, err = client.Version(context.Background(), &v1alpha1.VersionRequest{})
+	assert.Nil(t, err)
+	assert.Contains(t, logBuffer.String(), "CKCP083 Served Version in")
+}
+
+func TestInterceptorMetrics(t *testing.T) {
+	metrics.GRPCRequestDuration.Reset()
+	client := serveInterceptedMounts(t, ServerOptions{}, func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+		return nil, status.Error(codes.InvalidArgument, "invalid")
+	})
+
+	_, err := client.Mount(context.Background(), &v1alpha1.MountRequest{})
+	assert.NotNil(t, err)
+	_, err = client.Version(context.Background(), &v1alpha1.VersionRequest{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.GRPCRequestDuration))
+	assert.True(t, metrics.GRPCRequestDuration.DeleteLabelValues("Mount", "InvalidArgument"))
+	assert.True(t, metrics.GRPCRequestDuration.DeleteLabelValues("Version", "OK"))
+}
@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+)
+
+// DefaultMaxConcurrentMounts is the default number of mount requests served
+// at once.
+const DefaultMaxConcurrentMounts = 10
+
+// mountLimiter bounds the number of mount requests served at once. Requests
+// beyond the limit wait in a queue per application namespace, and slots are
+// handed to the namespaces in turn, so that a namespace restarting many pods
+// at once can't starve the others on the node.
+type mountLimiter struct {
+	// limit is the number of concurrent mounts allowed, or zero for no limit
+	limit int
+	now   func() time.Time
+
+	mutex  sync.Mutex
+	active int
+	// queues holds the requests waiting for a slot in each namespace, in the
+	// order they arrived
+	queues map[string][]chan struct{}
+	// turns holds each namespace with waiting requests, in the order they'll
+	// next be given a slot
+	turns []string
+}
+
+func newMountLimiter(limit int) *mountLimiter {
+	return &mountLimiter{
+		limit:  limit,
+		now:    time.Now,
+		queues: map[string][]chan struct{}{},
+	}
+}
+
+// acquire waits for a slot to serve a mount request for the given namespace,
+// returning a function which must be called to release it once the request
+// has been served. If ctx is done before a slot is free, an error categorized
+// as overloaded is returned.
+func (l *mountLimiter) acquire(ctx context.Context, namespace string) (func(), error) {
+	start := l.now()
+	l.mutex.Lock()
+	if l.limit <= 0 || (l.active < l.limit && len(l.turns) == 0) {
+		l.active++
+		l.mutex.Unlock()
+		return l.acquired(namespace, start), nil
+	}
+
+	ready := make(chan struct{})
+	if len(l.queues[namespace]) == 0 {
+		l.turns = append(l.turns, namespace)
+	}
+	l.queues[namespace] = append(l.queues[namespace], ready)
+	metrics.MountsQueued.Inc()
+	l.mutex.Unlock()
+
+	select {
+	case <-ready:
+		return l.acquired(namespace, start), nil
+	case <-ctx.Done():
+	}
+
+	l.mutex.Lock()
+	select {
+	case <-ready:
+		// A slot was handed over just as the request gave up, so pass it on
+		l.mutex.Unlock()
+		l.release()
+	default:
+		l.dequeue(namespace, ready)
+		metrics.MountsQueued.Dec()
+		l.mutex.Unlock()
+	}
+
+	waited := l.now().Sub(start)
+	metrics.MountQueueDuration.WithLabelValues(namespace, metrics.ResultError).Observe(waited.Seconds())
+	log.Error(logmessages.CKCP084, l.limit, waited.Round(time.Millisecond), ctx.Err())
+	return nil, logmessages.Errorf(logmessages.CKCP084, l.limit, waited.Round(time.Millisecond), ctx.Err())
+}
+
+// acquired records a request being given a slot, and returns the function
+// releasing it.
+func (l *mountLimiter) acquired(namespace string, start time.Time) func() {
+	metrics.MountQueueDuration.WithLabelValues(namespace, metrics.ResultSuccess).Observe(l.now().Sub(start).Seconds())
+	metrics.MountsInFlight.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			metrics.MountsInFlight.Dec()
+			l.release()
+		})
+	}
+}
+
+// release frees a slot, handing it to the first waiting request of the
+// namespace whose turn is next.
+func (l *mountLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.turns) == 0 {
+		l.active--
+		return
+	}
+
+	namespace := l.turns[0]
+	l.turns = l.turns[1:]
+	queue := l.queues[namespace]
+	next := queue[0]
+	if len(queue) > 1 {
+		l.queues[namespace] = queue[1:]
+		l.turns = append(l.turns, namespace)
+	} else {
+		delete(l.queues, namespace)
+	}
+
+	metrics.MountsQueued.Dec()
+	close(next)
+}
+
+// dequeue removes a request which gave up waiting from its namespace's queue.
+// The caller must hold the mutex.
+func (l *mountLimiter) dequeue(namespace string, ready chan struct{}) {
+	queue := l.queues[namespace]
+	for i, waiting := range queue {
+		if waiting == ready {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		l.queues[namespace] = queue
+		return
+	}
+
+	delete(l.queues, namespace)
+	for i, turn := range l.turns {
+		if turn == namespace {
+			l.turns = append(l.turns[:i:i], l.turns[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// queued returns the number of requests waiting for a slot.
+func (l *mountLimiter) queued() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	count := 0
+	for _, queue := range l.queues {
+		count += len(queue)
+	}
+	return count
+}
+
+// waitForQueued waits until the limiter has count requests waiting.
+func waitForQueued(t *testing.T, l *mountLimiter, count int) {
+	assert.Eventually(t, func() bool { return l.queued() == count }, time.Second, time.Millisecond)
+}
+
+func TestMountLimiterFairness(t *testing.T) {
+	limiter := newMountLimiter(1)
+	release, err := limiter.acquire(context.Background(), "namespace-a")
+	assert.Nil(t, err)
+
+	granted := make(chan string)
+	releases := make(chan func())
+	for i, request := range []string{"a2", "a3", "b1"} {
+		namespace := "namespace-" + request[:1]
+		go func() {
+			release, err := limiter.acquire(context.Background(), namespace)
+			assert.Nil(t, err)
+			granted <- request
+			releases <- release
+		}()
+		waitForQueued(t, limiter, i+1)
+	}
+
+	var order []string
+	for range 3 {
+		release()
+		order = append(order, <-granted)
+		release = <-releases
+	}
+	release()
+
+	// Namespaces take turns, so b1 is served before a3 despite arriving later
+	assert.Equal(t, []string{"a2", "b1", "a3"}, order)
+	assert.Equal(t, 0, limiter.active)
+	assert.Empty(t, limiter.turns)
+}
+
+func TestMountLimiterTimeout(t *testing.T) {
+	limiter := newMountLimiter(1)
+	release, err := limiter.acquire(context.Background(), "namespace-a")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, "namespace-b")
+
+	assert.ErrorContains(t, err, "CKCP084 Gave up waiting for one of 1 concurrent mount slots after")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, logmessages.CategoryOverloaded, logmessages.CategoryOf(err))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(logmessages.GRPCError(err)))
+	assert.Equal(t, 0, limiter.queued())
+	assert.Empty(t, limiter.turns)
+
+	// The slot is free once released, rather than handed to the abandoned request
+	release()
+	release, err = limiter.acquire(context.Background(), "namespace-b")
+	assert.Nil(t, err)
+	release()
+	assert.Equal(t, 0, limiter.active)
+}
+
+func TestMountLimiterUnlimited(t *testing.T) {
+	limiter := newMountLimiter(0)
+	for range 100 {
+		_, err := limiter.acquire(context.Background(), "namespace-a")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, limiter.queued())
+}
@@ -2,15 +2,28 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
-	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/k8s"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/tracing"
 	"github.com/hashicorp/go-version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
@@ -19,8 +32,27 @@ const providerName = "conjur"
 const saTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
 const podNameKey = "csi.storage.k8s.io/pod.name"
 const podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+const podUIDKey = "csi.storage.k8s.io/pod.uid"
+const secretProviderClassKey = "secretProviderClass"
 const configurationVersionKey = "conjur.org/configurationVersion"
 const secretsAnnotationKey = "conjur.org/secrets"
+const secretGroupAnnotationPrefix = "conjur.org/conjur-secrets."
+const secretFilePathAnnotationPrefix = "conjur.org/secret-file-path."
+const secretFileFormatAnnotationPrefix = "conjur.org/secret-file-format."
+const secretFileTemplateAnnotationPrefix = "conjur.org/secret-file-template."
+const sslCertificateKey = "sslCertificate"
+const sslCertificateConfigMapRefKey = "sslCertificateConfigMapRef"
+const sslCertificateSecretRefKey = "sslCertificateSecretRef"
+const defaultSSLCertificateRefKey = "ca.crt"
+const retryCountLimitKey = "retryCountLimit"
+const retryBaseDelayKey = "retryBaseDelay"
+const retryMaxDelayKey = "retryMaxDelay"
+const allowedPathPrefixesKey = "allowedPathPrefixes"
+
+// fileSpecObjectID is the ID of the object version reporting the
+// configuration of the mount's files, rather than a Conjur variable. Conjur
+// variable IDs never contain spaces, so it can't clash with one.
+const fileSpecObjectID = "conjur.org/file spec"
 
 // Config contains information parses from a Mount request that is required for
 // authenticating with Conjur and retrieving secrets.
@@ -29,15 +61,33 @@ type Config struct {
 	attributes map[string]string
 	// ServiceAccount JWT token used to authenticate to Conjur
 	token string
+	// Conjur appliance certificate, provided inline or read from a ConfigMap
+	// or Secret
+	sslCertificate string
 	// Desired permissions on generated secret files
 	permissions os.FileMode
-	// Secrets spec relating Conjur secret IDs to file paths
-	secrets map[string]string
+	// Files to be written to the mount and the Conjur secrets rendered into
+	// each of them
+	files []*secretFile
+	// Retry behavior for transient failures communicating with Conjur
+	retryPolicy conjur.RetryPolicy
+	// Directories, relative to the mount, that files must be written under.
+	// Any path within the mount is allowed when empty.
+	allowedPathPrefixes []string
 }
 
-// Mount implements a volume mount operation in the Conjur provider
-func Mount(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
-	return mountWithDeps(ctx, req, conjur.NewClient, k8s.GetPodAnnotations)
+// newMountFunc returns the volume mount operation of the Conjur provider,
+// which reads pod annotations and certificates using the given Kubernetes
+// client.
+func newMountFunc(kubeClient *k8s.Client) func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+	return func(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+		resp, err := mountWithDeps(ctx, req, conjur.NewClient, kubeClient.GetPodAnnotations, kubeClient.GetCertificate)
+		if err != nil {
+			namespace, podName := requestPod(req)
+			kubeClient.RecordMountFailure(namespace, podName, err)
+		}
+		return resp, err
+	}
 }
 
 // Version returns Conjur provider runtime details
@@ -54,43 +104,104 @@ func mountWithDeps(
 	req *v1alpha1.MountRequest,
 	conjurFactory conjur.ClientFactory,
 	getAnnotationsFunc k8s.GetPodAnnotationsFunc,
-) (*v1alpha1.MountResponse, error) {
-	cfg, err := NewConfig(req, getAnnotationsFunc)
+	getCertificateFunc k8s.GetCertificateFunc,
+) (resp *v1alpha1.MountResponse, err error) {
+	start := time.Now()
+	defer func() {
+		observeMount(req, resp, err, time.Since(start))
+	}()
+
+	logger := requestLogger(req)
+	ctx = logging.NewContext(ctx, logger)
+
+	namespace, podName := requestPod(req)
+	ctx, span := tracing.Tracer().Start(ctx, "provider.Mount", trace.WithAttributes(
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.name", podName),
+	))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	cfg, err := NewConfig(ctx, req, getAnnotationsFunc, getCertificateFunc)
 	if err != nil {
-		log.Error(logmessages.CKCP013, err)
-		return nil, fmt.Errorf(logmessages.CKCP013, err)
+		logger.Error(logmessages.CKCP013, err)
+		return nil, logmessages.Errorf(logmessages.CKCP013, err)
 	}
 
-	secretIDs := []string{}
-	for secretID, _ := range cfg.secrets {
-		secretIDs = append(secretIDs, secretID)
-	}
+	secretIDs := cfg.secretIDs()
 	conjClient := conjurFactory(
 		cfg.attributes["applianceUrl"],
 		cfg.attributes["authnId"],
 		cfg.attributes["account"],
 		cfg.attributes["identity"],
-		cfg.attributes["sslCertificate"],
+		cfg.sslCertificate,
+		cfg.retryPolicy,
 	)
-	secrets, err := conjClient.GetSecrets(cfg.token, secretIDs)
+	currentVersions := map[string]string{}
+	for _, ov := range req.GetCurrentObjectVersion() {
+		currentVersions[ov.GetId()] = ov.GetVersion()
+	}
+	// The files must be rendered again whenever their configuration changes,
+	// even if the secrets in them haven't, so their current values are needed
+	specVersion := fileSpecVersion(cfg.files, cfg.permissions)
+	specUnchanged := currentVersions[fileSpecObjectID] == specVersion
+	delete(currentVersions, fileSpecObjectID)
+	if !specUnchanged {
+		currentVersions = map[string]string{}
+	}
+
+	secrets, err := conjClient.GetSecrets(ctx, cfg.token, secretIDs, currentVersions)
 	if err != nil {
-		log.Error(logmessages.CKCP016, err)
-		return nil, fmt.Errorf(logmessages.CKCP016, err)
+		logger.Error(logmessages.CKCP016, err)
+		return nil, logmessages.Errorf(logmessages.CKCP016, err)
 	}
 
-	objectVersion := []*v1alpha1.ObjectVersion{}
-	files := []*v1alpha1.File{}
+	objectVersion := []*v1alpha1.ObjectVersion{{Id: fileSpecObjectID, Version: specVersion}}
+	unchanged := specUnchanged && len(secrets) == len(currentVersions)
 
-	for secretID, value := range secrets {
+	for secretID, secret := range secrets {
 		objectVersion = append(objectVersion, &v1alpha1.ObjectVersion{
 			Id:      secretID,
-			Version: "1",
-		})
-		files = append(files, &v1alpha1.File{
-			Path:     cfg.secrets[secretID],
-			Mode:     int32(cfg.permissions),
-			Contents: value,
+			Version: secret.Version,
 		})
+		if currentVersions[secretID] != secret.Version {
+			unchanged = false
+		}
+	}
+
+	files := []*v1alpha1.File{}
+	if !unchanged {
+		expandedFiles := []*secretFile{}
+		for _, f := range cfg.files {
+			expandedFiles = append(expandedFiles, f.expandPolicyBranch(secrets)...)
+		}
+		// Paths of files under a policy branch are only known once its
+		// variables have been listed
+		err = validateFilePaths(logger, expandedFiles, cfg.allowedPathPrefixes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, expanded := range expandedFiles {
+			contents, err := expanded.render(secrets)
+			if err != nil {
+				logger.Error(logmessages.CKCP047, expanded.path, err)
+				return nil, logmessages.Errorf(logmessages.CKCP047, expanded.path, err)
+			}
+			files = append(files, &v1alpha1.File{
+				Path:     expanded.path,
+				Mode:     int32(cfg.permissions),
+				Contents: contents,
+			})
+		}
+	}
+
+	// The CSI driver replaces the full set of files in the mount whenever a
+	// response contains any files, so either every file is returned or, when
+	// nothing has changed, none are and the existing files are left in place.
+	if unchanged {
+		logger.Info(logmessages.CKCP045, len(objectVersion))
 	}
 
 	return &v1alpha1.MountResponse{
@@ -99,157 +210,553 @@ func mountWithDeps(
 	}, nil
 }
 
-func parseRequestAttributes(req *v1alpha1.MountRequest) (map[string]string, error) {
+// fileSpecVersion identifies the configuration of the files written to the
+// mount, so that changes to it are detected like changes to secret versions.
+func fileSpecVersion(files []*secretFile, permissions os.FileMode) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%o\n", permissions)
+	for _, f := range files {
+		fmt.Fprintf(hash, "%q %q\n", f.path, f.format)
+		for _, s := range f.secrets {
+			fmt.Fprintf(hash, "%q %q\n", s.alias, s.id)
+		}
+		if f.template != nil && f.template.Tree != nil {
+			fmt.Fprintf(hash, "%q\n", f.template.Root.String())
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// requestPod returns the namespace and name of the application pod a mount
+// request was made for, if they can be determined. Attributes are parsed
+// independently of NewConfig, since they may be the reason a request failed.
+func requestPod(req *v1alpha1.MountRequest) (string, string) {
+	var attributes map[string]string
+	_ = json.Unmarshal([]byte(req.GetAttributes()), &attributes)
+	return attributes[podNamespaceKey], attributes[podNameKey]
+}
+
+// requestLogger returns a Logger which identifies the application pod and
+// SecretProviderClass of a mount request, along with a unique request ID, on
+// every line written while serving it.
+func requestLogger(req *v1alpha1.MountRequest) *logging.Logger {
+	var attributes map[string]string
+	_ = json.Unmarshal([]byte(req.GetAttributes()), &attributes)
+
+	logger := (&logging.Logger{}).With(logging.RequestIDKey, logging.NewRequestID())
+	for _, field := range []struct{ key, attribute string }{
+		{logging.PodKey, podNameKey},
+		{logging.NamespaceKey, podNamespaceKey},
+		{logging.SecretProviderClassKey, secretProviderClassKey},
+	} {
+		if value := attributes[field.attribute]; value != "" {
+			logger = logger.With(field.key, value)
+		}
+	}
+	return logger
+}
+
+// observeMount records metrics for a handled mount request.
+func observeMount(req *v1alpha1.MountRequest, resp *v1alpha1.MountResponse, err error, duration time.Duration) {
+	namespace, _ := requestPod(req)
+
+	result := metrics.Result(err)
+	if err == nil && len(resp.GetFiles()) == 0 {
+		result = metrics.ResultUnchanged
+	}
+
+	metrics.MountRequests.WithLabelValues(namespace, result, metrics.ErrorCode(err), string(logmessages.CategoryOf(err))).Inc()
+	metrics.MountDuration.WithLabelValues(namespace, result).Observe(duration.Seconds())
+	if err == nil {
+		secrets := 0
+		for _, ov := range resp.GetObjectVersion() {
+			if ov.GetId() != fileSpecObjectID {
+				secrets++
+			}
+		}
+		metrics.MountSecrets.WithLabelValues(namespace).Observe(float64(secrets))
+	}
+}
+
+func parseRequestAttributes(logger *logging.Logger, req *v1alpha1.MountRequest) (map[string]string, error) {
 	var attributes map[string]string
 
 	err := json.Unmarshal([]byte(req.GetAttributes()), &attributes)
 	if err != nil {
-		log.Error(logmessages.CKCP017, err)
-		return nil, fmt.Errorf(logmessages.CKCP017, err)
+		logger.Error(logmessages.CKCP017, err)
+		return nil, logmessages.Errorf(logmessages.CKCP017, err)
 	}
 
 	return attributes, nil
 }
 
-func NewConfig(req *v1alpha1.MountRequest, getAnnotationsFunc k8s.GetPodAnnotationsFunc) (*Config, error) {
+func NewConfig(
+	ctx context.Context,
+	req *v1alpha1.MountRequest,
+	getAnnotationsFunc k8s.GetPodAnnotationsFunc,
+	getCertificateFunc k8s.GetCertificateFunc,
+) (_ *Config, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "provider.NewConfig")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	var tokens map[string]map[string]string
 	var token string
 	var secretsStr string
-	var secrets map[string]string
+	var files []*secretFile
+	var groupFiles []*secretFile
 	var permissions os.FileMode
 	var configVersion *version.Version
-	var err error
+	logger := logging.FromContext(ctx)
 
-	attributes, err := parseRequestAttributes(req)
+	attributes, err := parseRequestAttributes(logger, req)
 	if err != nil {
-		log.Error(logmessages.CKCP032, err)
-		return nil, fmt.Errorf(logmessages.CKCP032, err)
+		logger.Error(logmessages.CKCP032, err)
+		return nil, logmessages.Errorf(logmessages.CKCP032, err)
 	}
 
 	configVersionStr := attributes[configurationVersionKey]
 	switch configVersionStr {
 	case "0.1.0", "0.2.0":
-		log.Info(logmessages.CKCP040, configVersionStr)
+		logger.Info(logmessages.CKCP040, configVersionStr)
 		configVersion, _ = version.NewVersion(configVersionStr)
 	case "":
-		log.Info(logmessages.CKCP041, "0.2.0")
+		logger.Info(logmessages.CKCP041, "0.2.0")
 		configVersion, _ = version.NewVersion("0.2.0")
 	default:
-		log.Error(logmessages.CKCP006, configVersionStr)
-		return nil, fmt.Errorf(logmessages.CKCP006, configVersionStr)
+		logger.Error(logmessages.CKCP006, configVersionStr)
+		return nil, logmessages.Errorf(logmessages.CKCP006, configVersionStr)
 	}
 
 	err = json.Unmarshal([]byte(attributes[saTokensKey]), &tokens)
 	if err != nil {
-		log.Error(logmessages.CKCP007, saTokensKey, err)
-		return nil, fmt.Errorf(logmessages.CKCP007, saTokensKey, err)
+		logger.Error(logmessages.CKCP007, saTokensKey, err)
+		return nil, logmessages.Errorf(logmessages.CKCP007, saTokensKey, err)
 	}
 
 	token = tokens[providerName]["token"]
 	if token == "" {
-		log.Error(logmessages.CKCP008, providerName)
-		return nil, fmt.Errorf(logmessages.CKCP008, providerName)
+		logger.Error(logmessages.CKCP008, providerName)
+		return nil, logmessages.Errorf(logmessages.CKCP008, providerName)
 	}
 
 	missingKeys := []string{}
 	// Don't check for 'identity' attribute since it is optional
-	for _, key := range []string{"account", "applianceUrl", "authnId", "sslCertificate"} {
+	for _, key := range []string{"account", "applianceUrl", "authnId"} {
 		if attributes[key] == "" {
 			missingKeys = append(missingKeys, key)
 		}
 	}
+	// The certificate may be provided inline or by reference
+	if attributes[sslCertificateKey] == "" &&
+		attributes[sslCertificateConfigMapRefKey] == "" &&
+		attributes[sslCertificateSecretRefKey] == "" {
+		missingKeys = append(missingKeys, sslCertificateKey)
+	}
 	if len(missingKeys) > 0 {
-		log.Error(logmessages.CKCP009, missingKeys)
-		return nil, fmt.Errorf(logmessages.CKCP009, missingKeys)
+		logger.Error(logmessages.CKCP009, missingKeys)
+		return nil, logmessages.Errorf(logmessages.CKCP009, missingKeys)
 	}
 
 	// Starting with configurationVersion 0.2.0, the 'secrets' attribute is
 	// retrieved from the 'conjur.org/secrets' annotation of the application pod.
 	// Prior to 0.2.0, the 'secrets' attribute is expected to be provided in the
 	// MountRequest attributes from the SecretProviderClass params.
+	// Secret groups, which render several secrets into a single file, are only
+	// supported in pod annotations.
 	annotationVersion, _ := version.NewVersion("0.2.0")
 	if configVersion.GreaterThanOrEqual(annotationVersion) {
-		secretsStr, err = retrievePodAnnotationSecrets(attributes, getAnnotationsFunc)
+		var annotations map[string]string
+		annotations, err = retrievePodAnnotations(ctx, attributes, getAnnotationsFunc)
+		if err == nil {
+			groupFiles, err = parseSecretGroups(logger, annotations)
+			if err != nil {
+				logger.Error(logmessages.CKCP011, err)
+				return nil, logmessages.Errorf(logmessages.CKCP011, err)
+			}
+
+			secretsStr = annotations[secretsAnnotationKey]
+			if secretsStr == "" && len(groupFiles) == 0 {
+				logger.Error(logmessages.CKCP034, secretsAnnotationKey)
+				err = logmessages.Errorf(logmessages.CKCP034, secretsAnnotationKey)
+			}
+		}
 		if err != nil {
 			// Fallback to SecretProviderClass attributes and log a deprecation warning
 			// if they are still being used
 			if attributes["secrets"] != "" {
-				log.Warn(logmessages.CKCP042)
+				logger.Warn(logmessages.CKCP042)
 				secretsStr = attributes["secrets"]
 			} else {
-				log.Error(logmessages.CKCP035, err)
-				return nil, fmt.Errorf(logmessages.CKCP035, err)
+				logger.Error(logmessages.CKCP035, err)
+				return nil, logmessages.Errorf(logmessages.CKCP035, err)
 			}
 		}
 	} else {
 		secretsStr = attributes["secrets"]
 	}
 
-	if secretsStr == "" {
-		log.Error(logmessages.CKCP010, "secrets")
-		return nil, fmt.Errorf(logmessages.CKCP010, "secrets")
+	if secretsStr == "" && len(groupFiles) == 0 {
+		logger.Error(logmessages.CKCP010, "secrets")
+		return nil, logmessages.Errorf(logmessages.CKCP010, "secrets")
 	}
 
-	secrets, err = parseSecrets(secretsStr)
+	if secretsStr != "" {
+		files, err = parseSecrets(logger, secretsStr)
+		if err != nil {
+			logger.Error(logmessages.CKCP011, err)
+			return nil, logmessages.Errorf(logmessages.CKCP011, err)
+		}
+	}
+	files = append(files, groupFiles...)
+
+	allowedPathPrefixes, err := parseAllowedPathPrefixes(logger, attributes)
+	if err != nil {
+		return nil, err
+	}
+	err = validateFilePaths(logger, files, allowedPathPrefixes)
 	if err != nil {
-		log.Error(logmessages.CKCP011, err)
-		return nil, fmt.Errorf(logmessages.CKCP011, err)
+		return nil, err
 	}
 
 	err = json.Unmarshal([]byte(req.GetPermission()), &permissions)
 	if err != nil {
-		log.Error(logmessages.CKCP012, err)
-		return nil, fmt.Errorf(logmessages.CKCP012, err)
+		logger.Error(logmessages.CKCP012, err)
+		return nil, logmessages.Errorf(logmessages.CKCP012, err)
+	}
+
+	sslCertificate, err := resolveSSLCertificate(attributes, getCertificateFunc)
+	if err != nil {
+		logger.Error(logmessages.CKCP059, err)
+		return nil, logmessages.Errorf(logmessages.CKCP059, err)
+	}
+
+	retryPolicy, err := parseRetryPolicy(logger, attributes)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Config{
-		attributes:  attributes,
-		token:       token,
-		permissions: permissions,
-		secrets:     secrets,
+		attributes:          attributes,
+		token:               token,
+		sslCertificate:      sslCertificate,
+		permissions:         permissions,
+		files:               files,
+		retryPolicy:         retryPolicy,
+		allowedPathPrefixes: allowedPathPrefixes,
 	}, nil
 }
 
+// resolveSSLCertificate returns the Conjur appliance certificate given inline
+// by the sslCertificate attribute, or read from the ConfigMap or Secret in the
+// application pod's namespace referred to by the sslCertificateConfigMapRef or
+// sslCertificateSecretRef attribute. References take the form "<name>" or
+// "<name>/<key>", with the key defaulting to "ca.crt".
+func resolveSSLCertificate(attributes map[string]string, getCertificateFunc k8s.GetCertificateFunc) (string, error) {
+	sources := []struct {
+		key  string
+		kind string
+	}{
+		{sslCertificateKey, ""},
+		{sslCertificateConfigMapRefKey, k8s.ConfigMapKind},
+		{sslCertificateSecretRefKey, k8s.SecretKind},
+	}
+
+	provided := []string{}
+	for _, source := range sources {
+		if attributes[source.key] != "" {
+			provided = append(provided, source.key)
+		}
+	}
+	if len(provided) > 1 {
+		return "", logmessages.Errorf(logmessages.CKCP055, provided)
+	}
+
+	for _, source := range sources {
+		value := attributes[source.key]
+		if value == "" {
+			continue
+		}
+		if source.kind == "" {
+			return value, nil
+		}
+
+		name, key, found := strings.Cut(value, "/")
+		if !found {
+			key = defaultSSLCertificateRefKey
+		}
+		if name == "" || key == "" || strings.Contains(key, "/") {
+			return "", logmessages.Errorf(logmessages.CKCP058, value, source.key)
+		}
+
+		return getCertificateFunc(attributes[podNamespaceKey], source.kind, name, key)
+	}
+
+	return "", nil
+}
+
+// parseRetryPolicy reads the optional retry attributes from the
+// SecretProviderClass parameters, falling back to conjur.DefaultRetryPolicy
+// for any that aren't set.
+func parseRetryPolicy(logger *logging.Logger, attributes map[string]string) (conjur.RetryPolicy, error) {
+	policy := conjur.DefaultRetryPolicy
+
+	if value := attributes[retryCountLimitKey]; value != "" {
+		limit, err := strconv.Atoi(value)
+		if err == nil && limit < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			logger.Error(logmessages.CKCP053, value, retryCountLimitKey, err)
+			return policy, logmessages.Errorf(logmessages.CKCP053, value, retryCountLimitKey, err)
+		}
+		policy.MaxRetries = limit
+	}
+
+	delays := []struct {
+		key   string
+		delay *time.Duration
+	}{
+		{retryBaseDelayKey, &policy.BaseDelay},
+		{retryMaxDelayKey, &policy.MaxDelay},
+	}
+	for _, d := range delays {
+		value := attributes[d.key]
+		if value == "" {
+			continue
+		}
+		delay, err := time.ParseDuration(value)
+		if err == nil && delay < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		if err != nil {
+			logger.Error(logmessages.CKCP053, value, d.key, err)
+			return policy, logmessages.Errorf(logmessages.CKCP053, value, d.key, err)
+		}
+		*d.delay = delay
+	}
+
+	return policy, nil
+}
+
+// parseAllowedPathPrefixes reads the optional list of directories that secret
+// files must be written under, separated by commas or whitespace, from the
+// SecretProviderClass parameters.
+func parseAllowedPathPrefixes(logger *logging.Logger, attributes map[string]string) ([]string, error) {
+	value := attributes[allowedPathPrefixesKey]
+	prefixes := []string{}
+	for _, prefix := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		if !filepath.IsLocal(prefix) {
+			err := fmt.Errorf("prefix %q must be a relative path within the mount", prefix)
+			logger.Error(logmessages.CKCP053, value, allowedPathPrefixesKey, err)
+			return nil, logmessages.Errorf(logmessages.CKCP053, value, allowedPathPrefixesKey, err)
+		}
+		prefixes = append(prefixes, path.Clean(prefix))
+	}
+	return prefixes, nil
+}
+
+// validateFilePaths normalizes the path of every secret file, and checks that
+// each file is written to its own path within the mount, under one of the
+// allowed prefixes if any are given. The CSI driver writes files to the paths
+// it's given, so this is what keeps secrets from being written elsewhere on
+// the node.
+func validateFilePaths(logger *logging.Logger, files []*secretFile, allowedPrefixes []string) error {
+	seen := map[string]bool{}
+	for _, f := range files {
+		reason := ""
+		_, branch := f.policyBranch()
+		switch {
+		case f.path == "":
+			reason = "path is empty"
+		case path.IsAbs(f.path):
+			reason = "absolute paths aren't allowed"
+		case !filepath.IsLocal(f.path):
+			reason = "path refers outside of the mount"
+		case path.Clean(f.path) == "." && !branch:
+			// A policy branch may be written to the root of the mount, and
+			// the paths of its files are checked once it's expanded
+			reason = "path refers to the mount itself"
+		case strings.HasPrefix(path.Clean(f.path), ".."):
+			// The CSI driver keeps the mount's current files under ..data
+			reason = `paths beginning with ".." are reserved by the CSI driver`
+		case seen[path.Clean(f.path)]:
+			reason = "path is used by more than one file"
+		case !hasPathPrefix(path.Clean(f.path), allowedPrefixes):
+			reason = fmt.Sprintf("path isn't under any of the allowed prefixes %q", allowedPrefixes)
+		}
+		if reason != "" {
+			logger.Error(logmessages.CKCP076, f.path, reason)
+			return logmessages.Errorf(logmessages.CKCP076, f.path, reason)
+		}
+		f.path = path.Clean(f.path)
+		seen[f.path] = true
+	}
+	return nil
+}
+
+// hasPathPrefix returns whether a clean relative path is, or is under, one of
+// the given directories. Every path has an empty list of prefixes.
+func hasPathPrefix(p string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if prefix == "." || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// secretIDs returns the unique Conjur secret IDs required to render all of
+// the Config's files.
+func (c *Config) secretIDs() []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, f := range c.files {
+		for _, s := range f.secrets {
+			if !seen[s.id] {
+				seen[s.id] = true
+				ids = append(ids, s.id)
+			}
+		}
+	}
+	return ids
+}
+
 // parseSecrets expect the input string in the format:
 //
 // - "file/path/A": "conjur/path/A"
 // - "file/path/B": "conjur/path/B"
+// - "file/dir/C": "conjur/policy/C/*"
 //
 // This format is recognized in YAML as a sequence of maps. Go's yaml.v3 package
 // can parse the input string into a []map[string]string object, and we can
-// transform the result into a map[string]string.
-func parseSecrets(s string) (map[string]string, error) {
+// transform the result into a list of plain secret files. Conjur paths ending
+// in "/*" refer to every variable under a policy branch, and each of them is
+// written beneath the given directory using its ID relative to the branch.
+func parseSecrets(logger *logging.Logger, s string) ([]*secretFile, error) {
 	var intermediate []map[string]string
 	err := yaml.Unmarshal([]byte(s), &intermediate)
 	if err != nil {
-		log.Error(logmessages.CKCP033, err)
-		return nil, fmt.Errorf(logmessages.CKCP033, err)
+		logger.Error(logmessages.CKCP033, err)
+		return nil, logmessages.Errorf(logmessages.CKCP033, err)
 	}
 
-	returned := make(map[string]string, len(intermediate))
+	returned := make([]*secretFile, 0, len(intermediate))
 	for _, i := range intermediate {
 		for k, v := range i {
-			returned[v] = k
+			returned = append(returned, &secretFile{
+				path:    k,
+				format:  fileFormatPlain,
+				secrets: []secretRef{{alias: path.Base(v), id: v}},
+			})
 		}
 	}
 
 	return returned, nil
 }
 
-// retrievePodAnnotationSecrets retrieves the annotation 'conjur.org/secrets'
-// from the pod that is associated with a given MountRequest. The annotation
-// value is assumed to match the YAML format expected by parseSecrets.
-func retrievePodAnnotationSecrets(attributes map[string]string, getAnnotationsFunc k8s.GetPodAnnotationsFunc) (string, error) {
-	annotations, err := getAnnotationsFunc(attributes[podNamespaceKey], attributes[podNameKey])
-	if err != nil {
-		log.Error(logmessages.CKCP033, err)
-		return "", fmt.Errorf(logmessages.CKCP033, err)
+// parseSecretGroups builds a secret file for each group of secrets defined in
+// the given pod annotations, following the conventions of the Secrets Provider
+// for Kubernetes:
+//
+//	conjur.org/conjur-secrets.<group>: |
+//	  - conjur/path/A
+//	  - aliasB: conjur/path/B
+//	conjur.org/secret-file-path.<group>: file/path/group.json
+//	conjur.org/secret-file-format.<group>: json
+//	conjur.org/secret-file-template.<group>: |
+//	  url: {{ secret "conjur/path/A" }}
+//
+// Secrets listed without an alias use the last element of their Conjur path.
+// The file format defaults to YAML, or to the template format when a template
+// is provided, and the file path defaults to the group name with the format's
+// extension.
+func parseSecretGroups(logger *logging.Logger, annotations map[string]string) ([]*secretFile, error) {
+	groups := []string{}
+	for key := range annotations {
+		if group, ok := strings.CutPrefix(key, secretGroupAnnotationPrefix); ok {
+			groups = append(groups, group)
+		}
 	}
+	sort.Strings(groups)
 
-	if annotations[secretsAnnotationKey] == "" {
-		log.Error(logmessages.CKCP034, secretsAnnotationKey)
-		return "", fmt.Errorf(logmessages.CKCP034, secretsAnnotationKey)
+	files := []*secretFile{}
+	for _, group := range groups {
+		var entries []any
+		err := yaml.Unmarshal([]byte(annotations[secretGroupAnnotationPrefix+group]), &entries)
+		if err != nil {
+			logger.Error(logmessages.CKCP046, group, err)
+			return nil, logmessages.Errorf(logmessages.CKCP046, group, err)
+		}
+
+		f := &secretFile{
+			path:   annotations[secretFilePathAnnotationPrefix+group],
+			format: annotations[secretFileFormatAnnotationPrefix+group],
+		}
+		if tmpl, ok := annotations[secretFileTemplateAnnotationPrefix+group]; ok {
+			f.template, err = parseTemplate(group, tmpl)
+			if err != nil {
+				logger.Error(logmessages.CKCP048, group, err)
+				return nil, logmessages.Errorf(logmessages.CKCP048, group, err)
+			}
+			if f.format == "" {
+				f.format = fileFormatTemplate
+			}
+		}
+		if f.format == "" {
+			f.format = fileFormatYAML
+		}
+		if f.path == "" {
+			f.path = fmt.Sprintf("%s.%s", group, fileFormatExtensions[f.format])
+		}
+
+		for _, entry := range entries {
+			switch e := entry.(type) {
+			case string:
+				f.secrets = append(f.secrets, secretRef{alias: path.Base(e), id: e})
+			case map[string]any:
+				for alias, id := range e {
+					idStr, ok := id.(string)
+					if !ok {
+						err = fmt.Errorf("secret ID for alias %q must be a string", alias)
+						logger.Error(logmessages.CKCP046, group, err)
+						return nil, logmessages.Errorf(logmessages.CKCP046, group, err)
+					}
+					f.secrets = append(f.secrets, secretRef{alias: alias, id: idStr})
+				}
+			default:
+				err = fmt.Errorf("unexpected entry %v", entry)
+				logger.Error(logmessages.CKCP046, group, err)
+				return nil, logmessages.Errorf(logmessages.CKCP046, group, err)
+			}
+		}
+
+		if err := f.validate(); err != nil {
+			logger.Error(logmessages.CKCP046, group, err)
+			return nil, logmessages.Errorf(logmessages.CKCP046, group, err)
+		}
+		files = append(files, f)
+	}
+
+	return files, nil
+}
+
+// retrievePodAnnotations retrieves the annotations of the pod that is
+// associated with a given MountRequest.
+func retrievePodAnnotations(ctx context.Context, attributes map[string]string, getAnnotationsFunc k8s.GetPodAnnotationsFunc) (map[string]string, error) {
+	_, span := tracing.Tracer().Start(ctx, "k8s.GetPodAnnotations")
+	annotations, err := getAnnotationsFunc(attributes[podNamespaceKey], attributes[podNameKey], attributes[podUIDKey])
+	tracing.End(span, err)
+	if err != nil {
+		logging.FromContext(ctx).Error(logmessages.CKCP033, err)
+		return nil, logmessages.Errorf(logmessages.CKCP033, err)
 	}
 
-	return annotations[secretsAnnotationKey], nil
+	return annotations, nil
 }
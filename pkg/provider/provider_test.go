@@ -8,29 +8,50 @@ import (
 	stdlog "log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/k8s"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
 type mockConjurClient struct {
-	resp map[string][]byte
+	resp map[string]conjur.Secret
 	err  error
 }
 
-func (c *mockConjurClient) GetSecrets(jwt string, secretIds []string) (map[string][]byte, error) {
+func (c *mockConjurClient) GetSecrets(ctx context.Context, jwt string, secretIds []string, currentVersions map[string]string) (map[string]conjur.Secret, error) {
 	return c.resp, c.err
 }
 
+// recordingConjurClient records the current versions it's given.
+type recordingConjurClient struct {
+	resp            map[string]conjur.Secret
+	currentVersions *map[string]string
+}
+
+func (c *recordingConjurClient) GetSecrets(ctx context.Context, jwt string, secretIds []string, currentVersions map[string]string) (map[string]conjur.Secret, error) {
+	*c.currentVersions = currentVersions
+	return c.resp, nil
+}
+
 func TestMount(t *testing.T) {
 	testCases := []struct {
 		description        string
 		req                *v1alpha1.MountRequest
 		conjurFactory      conjur.ClientFactory
 		getAnnotationsFunc k8s.GetPodAnnotationsFunc
+		getCertificateFunc k8s.GetCertificateFunc
 		assertions         func(*testing.T, *v1alpha1.MountResponse, error, bytes.Buffer)
 	}{
 		{
@@ -108,7 +129,7 @@ func TestMount(t *testing.T) {
 			req: &v1alpha1.MountRequest{
 				Attributes: `{"sslCertificate":"certificate content","secrets":"","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"conjur.org/secrets":    "",
 					"some-other-annotation": "some-value",
@@ -124,7 +145,7 @@ func TestMount(t *testing.T) {
 			req: &v1alpha1.MountRequest{
 				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"conjur.org/secrets":    "invalid",
 					"some-other-annotation": "some-value",
@@ -141,7 +162,7 @@ func TestMount(t *testing.T) {
 				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
 				Permission: "abc",
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"conjur.org/secrets": "- \"file/path/A\": \"conjur/path/A\"\n- \"file/path/B\": \"conjur/path/B\"\n",
 				}, nil
@@ -158,12 +179,12 @@ func TestMount(t *testing.T) {
 				Permission: "777",
 				TargetPath: "/some/path",
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"conjur.org/secrets": "- \"file/path/A\": \"conjur/path/A\"\n- \"file/path/B\": \"conjur/path/B\"\n",
 				}, nil
 			},
-			conjurFactory: func(baseURL, authnID, account, identity, sslCert string) conjur.Client {
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
 				return &mockConjurClient{
 					resp: nil,
 					err:  errors.New("Conjur error getting secrets"),
@@ -181,11 +202,11 @@ func TestMount(t *testing.T) {
 				Permission: "777",
 				TargetPath: "/some/path",
 			},
-			conjurFactory: func(baseURL, authnID, account, identity, sslCert string) conjur.Client {
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
 				return &mockConjurClient{
-					resp: map[string][]byte{
-						"conjur/path/A": []byte("contentA"),
-						"conjur/path/B": []byte("contentB"),
+					resp: map[string]conjur.Secret{
+						"conjur/path/A": {Value: []byte("contentA"), Version: "1"},
+						"conjur/path/B": {Value: []byte("contentB"), Version: "3"},
 					},
 					err: nil,
 				}
@@ -193,7 +214,7 @@ func TestMount(t *testing.T) {
 			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
 				assert.Nil(t, err)
 
-				assert.Len(t, resp.ObjectVersion, 2)
+				assert.Len(t, resp.ObjectVersion, 3)
 				assert.Len(t, resp.Files, 2)
 
 				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
@@ -202,7 +223,7 @@ func TestMount(t *testing.T) {
 				})
 				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
 					Id:      "conjur/path/B",
-					Version: "1",
+					Version: "3",
 				})
 				assert.Contains(t, resp.Files, &v1alpha1.File{
 					Path:     "file/path/A",
@@ -223,16 +244,16 @@ func TestMount(t *testing.T) {
 				Permission: "777",
 				TargetPath: "/some/path",
 			},
-			conjurFactory: func(baseURL, authnID, account, identity, sslCert string) conjur.Client {
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
 				return &mockConjurClient{
-					resp: map[string][]byte{
-						"conjur/path/A": []byte("contentA"),
-						"conjur/path/B": []byte("contentB"),
+					resp: map[string]conjur.Secret{
+						"conjur/path/A": {Value: []byte("contentA"), Version: "1"},
+						"conjur/path/B": {Value: []byte("contentB"), Version: "3"},
 					},
 					err: nil,
 				}
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"some-annotation": "some-value",
 				}, nil
@@ -240,7 +261,7 @@ func TestMount(t *testing.T) {
 			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
 				assert.Nil(t, err)
 
-				assert.Len(t, resp.ObjectVersion, 2)
+				assert.Len(t, resp.ObjectVersion, 3)
 				assert.Len(t, resp.Files, 2)
 
 				assert.Contains(t, logs.String(), "WARN")
@@ -252,7 +273,7 @@ func TestMount(t *testing.T) {
 				})
 				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
 					Id:      "conjur/path/B",
-					Version: "1",
+					Version: "3",
 				})
 				assert.Contains(t, resp.Files, &v1alpha1.File{
 					Path:     "file/path/A",
@@ -273,16 +294,16 @@ func TestMount(t *testing.T) {
 				Permission: "777",
 				TargetPath: "/some/path",
 			},
-			conjurFactory: func(baseURL, authnID, account, identity, sslCert string) conjur.Client {
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
 				return &mockConjurClient{
-					resp: map[string][]byte{
-						"conjur/path/A": []byte("contentA"),
-						"conjur/path/B": []byte("contentB"),
+					resp: map[string]conjur.Secret{
+						"conjur/path/A": {Value: []byte("contentA"), Version: "1"},
+						"conjur/path/B": {Value: []byte("contentB"), Version: "3"},
 					},
 					err: nil,
 				}
 			},
-			getAnnotationsFunc: func(namespace string, podName string) (map[string]string, error) {
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
 				return map[string]string{
 					"conjur.org/secrets":    "- \"file/path/A\": \"conjur/path/A\"\n- \"file/path/B\": \"conjur/path/B\"\n",
 					"some-other-annotation": "some-value",
@@ -291,7 +312,7 @@ func TestMount(t *testing.T) {
 			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
 				assert.Nil(t, err)
 
-				assert.Len(t, resp.ObjectVersion, 2)
+				assert.Len(t, resp.ObjectVersion, 3)
 				assert.Len(t, resp.Files, 2)
 
 				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
@@ -300,7 +321,7 @@ func TestMount(t *testing.T) {
 				})
 				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
 					Id:      "conjur/path/B",
-					Version: "1",
+					Version: "3",
 				})
 				assert.Contains(t, resp.Files, &v1alpha1.File{
 					Path:     "file/path/A",
@@ -314,18 +335,758 @@ func TestMount(t *testing.T) {
 				})
 			},
 		},
+		{
+			description: "returns no files when all secrets match current object versions",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+				CurrentObjectVersion: []*v1alpha1.ObjectVersion{
+					{Id: "conjur/path/A", Version: "1"},
+					{Id: "conjur/path/B", Version: "3"},
+					{Id: fileSpecObjectID, Version: fileSpecVersion([]*secretFile{
+						{path: "file/path/A", format: fileFormatPlain, secrets: []secretRef{{alias: "A", id: "conjur/path/A"}}},
+						{path: "file/path/B", format: fileFormatPlain, secrets: []secretRef{{alias: "B", id: "conjur/path/B"}}},
+					}, 777)},
+				},
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"conjur/path/A": {Version: "1"},
+						"conjur/path/B": {Version: "3"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"file/path/A\": \"conjur/path/A\"\n- \"file/path/B\": \"conjur/path/B\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Empty(t, resp.Files)
+				assert.Contains(t, logs.String(), "CKCP045")
+			},
+		},
+		{
+			description: "returns all files when any secret differs from current object versions",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+				CurrentObjectVersion: []*v1alpha1.ObjectVersion{
+					{Id: "conjur/path/A", Version: "1"},
+					{Id: "conjur/path/B", Version: "2"},
+					{Id: fileSpecObjectID, Version: fileSpecVersion([]*secretFile{
+						{path: "file/path/A", format: fileFormatPlain, secrets: []secretRef{{alias: "A", id: "conjur/path/A"}}},
+						{path: "file/path/B", format: fileFormatPlain, secrets: []secretRef{{alias: "B", id: "conjur/path/B"}}},
+					}, 777)},
+				},
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"conjur/path/A": {Value: []byte("contentA"), Version: "1"},
+						"conjur/path/B": {Value: []byte("contentB"), Version: "3"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"file/path/A\": \"conjur/path/A\"\n- \"file/path/B\": \"conjur/path/B\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Len(t, resp.Files, 2)
+				assert.Contains(t, resp.ObjectVersion, &v1alpha1.ObjectVersion{
+					Id:      "conjur/path/B",
+					Version: "3",
+				})
+			},
+		},
+		{
+			description: "throws error when secret group is invalid (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/conjur-secrets.db":     "- db/user\n- db/password\n",
+					"conjur.org/secret-file-format.db": "xml",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP046 Invalid secret group "db": unsupported format "xml"`)
+			},
+		},
+		{
+			description: "happy path with secret groups (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"db/user":     {Value: []byte("admin"), Version: "1"},
+						"db/password": {Value: []byte("secret"), Version: "2"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets":                "- \"raw/password\": \"db/password\"\n",
+					"conjur.org/conjur-secrets.db":      "- db/user\n- DB_PASS: db/password\n",
+					"conjur.org/secret-file-format.db":  "json",
+					"conjur.org/conjur-secrets.env":     "- DB_USER: db/user\n",
+					"conjur.org/secret-file-format.env": "dotenv",
+					"conjur.org/secret-file-path.env":   "config/db.env",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Len(t, resp.Files, 3)
+
+				assert.Contains(t, resp.Files, &v1alpha1.File{
+					Path:     "raw/password",
+					Mode:     int32(777),
+					Contents: []byte("secret"),
+				})
+				assert.Contains(t, resp.Files, &v1alpha1.File{
+					Path:     "db.json",
+					Mode:     int32(777),
+					Contents: []byte("{\"user\":\"admin\",\"DB_PASS\":\"secret\"}\n"),
+				})
+				assert.Contains(t, resp.Files, &v1alpha1.File{
+					Path:     "config/db.env",
+					Mode:     int32(777),
+					Contents: []byte("DB_USER=\"admin\"\n"),
+				})
+			},
+		},
+		{
+			description: "throws error when secret group template fails to parse (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/conjur-secrets.db":       "- db/user\n",
+					"conjur.org/secret-file-template.db": "{{ secret \"db/user\" ",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP048 Failed to parse template for secret group "db"`)
+			},
+		},
+		{
+			description: "throws error when secret group template uses an unsupported function (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/conjur-secrets.db":       "- db/user\n",
+					"conjur.org/secret-file-template.db": "{{ env \"HOME\" }}",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `function "env" not defined`)
+			},
+		},
+		{
+			description: "happy path with secret group template (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"db/user":     {Value: []byte("admin"), Version: "1"},
+						"db/password": {Value: []byte("secret"), Version: "2"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/conjur-secrets.db":       "- db/user\n- db/password\n",
+					"conjur.org/secret-file-path.db":     "db-url",
+					"conjur.org/secret-file-template.db": "postgres://{{ secret \"db/user\" }}:{{ secret \"password\" }}@db:5432",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Equal(t, []*v1alpha1.File{{
+					Path:     "db-url",
+					Mode:     int32(777),
+					Contents: []byte("postgres://admin:secret@db:5432"),
+				}}, resp.Files)
+			},
+		},
+		{
+			description: "happy path with policy branch (v0.2.0)",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"db-credentials/url":      {Value: []byte("url"), Version: "1"},
+						"db-credentials/password": {Value: []byte("password"), Version: "2"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"db\": \"db-credentials/*\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Equal(t, []*v1alpha1.File{
+					{Path: "db/password", Mode: int32(777), Contents: []byte("password")},
+					{Path: "db/url", Mode: int32(777), Contents: []byte("url")},
+				}, resp.Files)
+			},
+		},
+		{
+			description: "expands policy branch into the root of the mount",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"db-credentials/url":      {Value: []byte("url"), Version: "1"},
+						"db-credentials/password": {Value: []byte("password"), Version: "2"},
+					},
+					err: nil,
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \".\": \"db-credentials/*\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+
+				assert.Len(t, resp.ObjectVersion, 3)
+				assert.Equal(t, []*v1alpha1.File{
+					{Path: "password", Mode: int32(777), Contents: []byte("password")},
+					{Path: "url", Mode: int32(777), Contents: []byte("url")},
+				}, resp.Files)
+			},
+		},
+		{
+			description: "throws error for invalid retry attribute",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"retryBaseDelay":"soon","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `Invalid value "soon" for attribute "retryBaseDelay"`)
+			},
+		},
+		{
+			description: "passes retry attributes to Conjur client",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"retryCountLimit":"5","retryMaxDelay":"10s","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				expected := conjur.RetryPolicy{
+					MaxRetries: 5,
+					BaseDelay:  conjur.DefaultRetryPolicy.BaseDelay,
+					MaxDelay:   10 * time.Second,
+				}
+				if retry != expected {
+					return &mockConjurClient{err: fmt.Errorf("unexpected retry policy %+v", retry)}
+				}
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"path/to/secret/A": {Value: []byte("secretA"), Version: "1"},
+					},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+				assert.Len(t, resp.Files, 1)
+			},
+		},
+		{
+			description: "preserves typed Conjur request errors",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					err: &conjur.RequestError{StatusCode: 403},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				var requestErr *conjur.RequestError
+				assert.True(t, errors.As(err, &requestErr))
+				assert.Equal(t, 403, requestErr.StatusCode)
+			},
+		},
+		{
+			description: "loads SSL certificate from ConfigMap reference",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificateConfigMapRef":"conjur-ca","csi.storage.k8s.io/pod.namespace":"app-namespace","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				if sslCert != "configmap certificate" {
+					return &mockConjurClient{err: fmt.Errorf("unexpected certificate %q", sslCert)}
+				}
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"path/to/secret/A": {Value: []byte("secretA"), Version: "1"},
+					},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			getCertificateFunc: func(namespace string, kind string, name string, key string) (string, error) {
+				if namespace != "app-namespace" || kind != k8s.ConfigMapKind || name != "conjur-ca" || key != "ca.crt" {
+					return "", fmt.Errorf("unexpected reference %s %s/%s/%s", kind, namespace, name, key)
+				}
+				return "configmap certificate", nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+				assert.Len(t, resp.Files, 1)
+			},
+		},
+		{
+			description: "loads SSL certificate from Secret reference with key",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificateSecretRef":"conjur-tls/conjur.pem","csi.storage.k8s.io/pod.namespace":"app-namespace","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				if sslCert != "secret certificate" {
+					return &mockConjurClient{err: fmt.Errorf("unexpected certificate %q", sslCert)}
+				}
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"path/to/secret/A": {Value: []byte("secretA"), Version: "1"},
+					},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			getCertificateFunc: func(namespace string, kind string, name string, key string) (string, error) {
+				if namespace != "app-namespace" || kind != k8s.SecretKind || name != "conjur-tls" || key != "conjur.pem" {
+					return "", fmt.Errorf("unexpected reference %s %s/%s/%s", kind, namespace, name, key)
+				}
+				return "secret certificate", nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+				assert.Len(t, resp.Files, 1)
+			},
+		},
+		{
+			description: "throws error when more than one SSL certificate source is provided",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","sslCertificateConfigMapRef":"conjur-ca","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `Only one of ["sslCertificate" "sslCertificateConfigMapRef"] may be provided`)
+			},
+		},
+		{
+			description: "throws error for invalid SSL certificate reference",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificateConfigMapRef":"conjur-ca/ca.crt/extra","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `Invalid value "conjur-ca/ca.crt/extra" for attribute "sslCertificateConfigMapRef"`)
+			},
+		},
+		{
+			description: "throws error when SSL certificate reference can't be read",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificateSecretRef":"conjur-tls","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			getCertificateFunc: func(namespace string, kind string, name string, key string) (string, error) {
+				return "", errors.New("secrets \"conjur-tls\" is forbidden")
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `Failed to load Conjur SSL certificate: secrets "conjur-tls" is forbidden`)
+			},
+		},
+		{
+			description: "throws error for secret file path outside of the mount",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"relative/../../etc/x\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP076 Invalid secret file path "relative/../../etc/x": path refers outside of the mount`)
+				assert.Equal(t, logmessages.CategoryConfig, logmessages.CategoryOf(err))
+			},
+		},
+		{
+			description: "throws error for absolute secret file path",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"/abs/path\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP076 Invalid secret file path "/abs/path": absolute paths aren't allowed`)
+			},
+		},
+		{
+			description: "throws error for duplicate secret file paths",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"fileA.txt\": \"path/to/secret/A\"\n- \"./fileA.txt\": \"path/to/secret/B\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `path is used by more than one file`)
+			},
+		},
+		{
+			description: "throws error for policy branch variable written to a duplicate path",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"db-credentials/url": {Value: []byte("url"), Version: "1"},
+						"path/to/secret/A":   {Value: []byte("secretA"), Version: "1"},
+					},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"db\": \"db-credentials/*\"\n- \"db/url\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP076 Invalid secret file path "db/url": path is used by more than one file`)
+			},
+		},
+		{
+			description: "normalizes secret file paths under allowed prefixes",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"allowedPathPrefixes":"config/, secrets","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			conjurFactory: func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+				return &mockConjurClient{
+					resp: map[string]conjur.Secret{
+						"path/to/secret/A": {Value: []byte("secretA"), Version: "1"},
+					},
+				}
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"secrets/./nested//fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, err)
+				assert.Equal(t, []*v1alpha1.File{
+					{Path: "secrets/nested/fileA.txt", Mode: int32(777), Contents: []byte("secretA")},
+				}, resp.Files)
+			},
+		},
+		{
+			description: "throws error for secret file path not under allowed prefixes",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"allowedPathPrefixes":"config,secrets","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"secretsfile.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP076 Invalid secret file path "secretsfile.txt": path isn't under any of the allowed prefixes ["config" "secrets"]`)
+			},
+		},
+		{
+			description: "throws error for allowed prefix outside of the mount",
+			req: &v1alpha1.MountRequest{
+				Attributes: `{"allowedPathPrefixes":"../shared","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`,
+				Permission: "777",
+				TargetPath: "/some/path",
+			},
+			getAnnotationsFunc: func(namespace string, podName string, podUID string) (map[string]string, error) {
+				return map[string]string{
+					"conjur.org/secrets": "- \"fileA.txt\": \"path/to/secret/A\"\n",
+				}, nil
+			},
+			assertions: func(t *testing.T, resp *v1alpha1.MountResponse, err error, logs bytes.Buffer) {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), `CKCP053 Invalid value "../shared" for attribute "allowedPathPrefixes": prefix "../shared" must be a relative path within the mount`)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
 			var logBuffer bytes.Buffer
 			log.InfoLogger = stdlog.New(&logBuffer, "", 0)
-			resp, err := mountWithDeps(context.TODO(), tc.req, tc.conjurFactory, tc.getAnnotationsFunc)
+			resp, err := mountWithDeps(context.TODO(), tc.req, tc.conjurFactory, tc.getAnnotationsFunc, tc.getCertificateFunc)
 			tc.assertions(t, resp, err, logBuffer)
 		})
 	}
 }
 
+func TestMountFileSpecChanges(t *testing.T) {
+	attributes := `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`
+	annotations := map[string]string{
+		"conjur.org/conjur-secrets.db":     "- user: db/user\n",
+		"conjur.org/secret-file-format.db": "yaml",
+		"conjur.org/secret-file-path.db":   "db.yaml",
+	}
+	getAnnotationsFunc := func(namespace string, podName string, podUID string) (map[string]string, error) {
+		return annotations, nil
+	}
+	var currentVersions map[string]string
+	conjurFactory := func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+		return &recordingConjurClient{
+			resp:            map[string]conjur.Secret{"db/user": {Value: []byte("admin"), Version: "1"}},
+			currentVersions: &currentVersions,
+		}
+	}
+	mount := func(permission string, current []*v1alpha1.ObjectVersion) *v1alpha1.MountResponse {
+		resp, err := mountWithDeps(context.TODO(), &v1alpha1.MountRequest{
+			Attributes:           attributes,
+			Permission:           permission,
+			CurrentObjectVersion: current,
+		}, conjurFactory, getAnnotationsFunc, nil)
+		assert.Nil(t, err)
+		return resp
+	}
+
+	first := mount("420", nil)
+	assert.Len(t, first.Files, 1)
+
+	unchanged := mount("420", first.ObjectVersion)
+	assert.Empty(t, unchanged.Files)
+	assert.Equal(t, map[string]string{"db/user": "1"}, currentVersions)
+
+	// Secret values are retrieved again, since the files must be rendered
+	// with them despite their versions being unchanged
+	permissionChanged := mount("384", first.ObjectVersion)
+	assert.Len(t, permissionChanged.Files, 1)
+	assert.Equal(t, int32(384), permissionChanged.Files[0].Mode)
+	assert.Empty(t, currentVersions)
+
+	annotations["conjur.org/secret-file-format.db"] = "json"
+	formatChanged := mount("420", first.ObjectVersion)
+	assert.Len(t, formatChanged.Files, 1)
+	assert.Equal(t, "{\"user\":\"admin\"}\n", string(formatChanged.Files[0].Contents))
+}
+
+func TestMountMetrics(t *testing.T) {
+	attributes := `{"csi.storage.k8s.io/pod.namespace":"metrics-namespace","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`
+	getAnnotationsFunc := func(namespace string, podName string, podUID string) (map[string]string, error) {
+		return map[string]string{
+			"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+		}, nil
+	}
+	conjurFactory := func(err error) conjur.ClientFactory {
+		return func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+			return &mockConjurClient{
+				resp: map[string]conjur.Secret{
+					"path/to/secret/A": {Value: []byte("secretA"), Version: "1"},
+				},
+				err: err,
+			}
+		}
+	}
+
+	resp, _ := mountWithDeps(context.TODO(), &v1alpha1.MountRequest{Attributes: attributes, Permission: "777"}, conjurFactory(nil), getAnnotationsFunc, nil)
+	mountWithDeps(context.TODO(), &v1alpha1.MountRequest{Attributes: attributes, Permission: "777"}, conjurFactory(logmessages.NewError(logmessages.CategoryTransient, logmessages.CKCP031, errors.New("timeout"))), getAnnotationsFunc, nil)
+	mountWithDeps(context.TODO(), &v1alpha1.MountRequest{
+		Attributes:           attributes,
+		Permission:           "777",
+		CurrentObjectVersion: resp.GetObjectVersion(),
+	}, conjurFactory(nil), getAnnotationsFunc, nil)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.MountRequests.WithLabelValues("metrics-namespace", metrics.ResultSuccess, "", "")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.MountRequests.WithLabelValues("metrics-namespace", metrics.ResultError, "CKCP031", "transient")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.MountRequests.WithLabelValues("metrics-namespace", metrics.ResultUnchanged, "", "")))
+}
+
+func TestMountLogFields(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.ErrorLogger = stdlog.New(&logBuffer, "", 0)
+	attributes := `{"csi.storage.k8s.io/pod.name":"app","csi.storage.k8s.io/pod.namespace":"app-namespace","secretProviderClass":"credentials-from-conjur","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\"}}"}`
+	getAnnotationsFunc := func(namespace string, podName string, podUID string) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+
+	_, err := mountWithDeps(context.TODO(), &v1alpha1.MountRequest{Attributes: attributes, Permission: "777"}, nil, getAnnotationsFunc, nil)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, logBuffer.String(), "CKCP009 Missing required Conjur config attributes")
+	assert.Regexp(t, `requestId="[0-9a-f]{16}" pod="app" namespace="app-namespace" secretProviderClass="credentials-from-conjur"\n`, logBuffer.String())
+}
+
+func TestMountPodIdentity(t *testing.T) {
+	attributes := `{"sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/pod.name":"app","csi.storage.k8s.io/pod.namespace":"app-namespace","csi.storage.k8s.io/pod.uid":"8e1f4c2a","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\"}}"}`
+	var pod []string
+	getAnnotationsFunc := func(namespace string, podName string, podUID string) (map[string]string, error) {
+		pod = []string{namespace, podName, podUID}
+		return map[string]string{}, nil
+	}
+
+	mountWithDeps(context.TODO(), &v1alpha1.MountRequest{Attributes: attributes, Permission: "777"}, nil, getAnnotationsFunc, nil)
+
+	// The UID distinguishes the pod from any deleted pod of the same name
+	assert.Equal(t, []string{"app-namespace", "app", "8e1f4c2a"}, pod)
+}
+
+func TestMountTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	attributes := `{"csi.storage.k8s.io/pod.name":"app","csi.storage.k8s.io/pod.namespace":"app-namespace","sslCertificate":"certificate content","account":"default","applianceUrl":"https://my.conjur.com","authnId":"authn-jwt/instance","csi.storage.k8s.io/serviceAccount.tokens":"{\"conjur\":{\"token\":\"sometoken\",\"expirationTimestamp\":\"2123-01-01T01:01:01Z\"}}"}`
+	getAnnotationsFunc := func(namespace string, podName string, podUID string) (map[string]string, error) {
+		return map[string]string{
+			"conjur.org/secrets": "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+		}, nil
+	}
+	conjurFactory := func(baseURL, authnID, account, identity, sslCert string, retry conjur.RetryPolicy) conjur.Client {
+		return &mockConjurClient{resp: map[string]conjur.Secret{"path/to/secret/A": {Value: []byte("secretA"), Version: "1"}}}
+	}
+
+	_, err := mountWithDeps(context.TODO(), &v1alpha1.MountRequest{Attributes: attributes, Permission: "777"}, conjurFactory, getAnnotationsFunc, nil)
+	assert.Nil(t, err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	assert.Len(t, spans, 3)
+	mount := spans["provider.Mount"]
+	assert.Contains(t, mount.Attributes(), attribute.String("k8s.pod.name", "app"))
+	assert.Contains(t, mount.Attributes(), attribute.String("k8s.namespace.name", "app-namespace"))
+	assert.Equal(t, mount.SpanContext().SpanID(), spans["provider.NewConfig"].Parent().SpanID())
+	assert.Equal(t, spans["provider.NewConfig"].SpanContext().SpanID(), spans["k8s.GetPodAnnotations"].Parent().SpanID())
+}
+
 func TestVersion(t *testing.T) {
 	testCases := []struct {
 		description string
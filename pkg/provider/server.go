@@ -2,23 +2,46 @@ package provider
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io/fs"
 	"net"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/conjur"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/k8s"
 	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
 const DefaultSocketPath string = "/var/run/secrets-store-csi-providers/conjur.sock"
 
+// socketMode restricts the socket to the provider's own user. The CSI driver
+// connects as root, so needs no further permissions.
+const socketMode os.FileMode = 0o600
+
+// staleSocketTimeout is how long to wait for a connection to an existing
+// socket before considering it stale.
+const staleSocketTimeout = time.Second
+
+// DefaultDrainTimeout is how long the provider waits for in-flight requests to
+// complete when stopping, which must be within the pod's termination grace
+// period.
+const DefaultDrainTimeout = 20 * time.Second
+
 type grpcServer interface {
 	RegisterService(*grpc.ServiceDesc, any)
 	Serve(net.Listener) error
 	GracefulStop()
+	Stop()
 }
 
 // ConjurProviderServer is an implementation of the v1alpha1.CSIDriverProviderServer
@@ -27,22 +50,58 @@ type ConjurProviderServer struct {
 	socketPath  string
 	grpcServer  grpcServer
 	listener    net.Listener
+	limiter     *mountLimiter
 	mountFunc   func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error)
 	versionFunc func(context.Context, *v1alpha1.VersionRequest) (*v1alpha1.VersionResponse, error)
+
+	// checkAppliances checks the Conjur appliances used to serve recent mount
+	// requests, for the gRPC health service
+	checkAppliances func(context.Context) []conjur.ApplianceStatus
+
+	serving  atomic.Bool
+	draining atomic.Bool
+	// drained is closed once the server starts shutting down
+	drained chan struct{}
+
+	mutex    sync.Mutex
+	nextID   uint64
+	inFlight map[uint64]inFlightMount
 }
 
-// NewServer returns the default ConjurProviderServer struct.
-func NewServer(socketPath string) *ConjurProviderServer {
+// inFlightMount identifies a mount request being served, so that it can be
+// reported if abandoned when the server stops.
+type inFlightMount struct {
+	namespace string
+	name      string
+	start     time.Time
+}
+
+// ServerOptions configure how a ConjurProviderServer serves requests.
+type ServerOptions struct {
+	// RequestTimeout is the deadline given to requests which the CSI driver
+	// sends without one. Zero leaves them without a deadline.
+	RequestTimeout time.Duration
+	// MaxConcurrentMounts is the number of mount requests served at once, with
+	// the rest queued fairly across application namespaces. Zero disables the
+	// limit.
+	MaxConcurrentMounts int
+}
+
+// NewServer returns the default ConjurProviderServer struct, using kubeClient
+// to read application pods and Conjur certificates.
+func NewServer(socketPath string, kubeClient *k8s.Client, opts ServerOptions) *ConjurProviderServer {
 	return newServerWithDeps(
 		socketPath,
+		opts,
 		func(opt ...grpc.ServerOption) grpcServer { return grpc.NewServer(opt...) },
-		Mount,
+		newMountFunc(kubeClient),
 		Version,
 	)
 }
 
 func newServerWithDeps(
 	socketPath string,
+	opts ServerOptions,
 	grpcFactory func(...grpc.ServerOption) grpcServer,
 	mountFunc func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error),
 	versionFunc func(context.Context, *v1alpha1.VersionRequest) (*v1alpha1.VersionResponse, error),
@@ -50,20 +109,29 @@ func newServerWithDeps(
 	log.Info(logmessages.CKCP018)
 	validateSocket(socketPath)
 
-	grpcServer := grpcFactory()
+	grpcServer := grpcFactory(
+		grpc.ChainUnaryInterceptor(unaryInterceptors(opts)...),
+		grpc.ChainStreamInterceptor(streamInterceptors()...),
+	)
 	providerServer := &ConjurProviderServer{
 		socketPath:  socketPath,
 		grpcServer:  grpcServer,
+		limiter:     newMountLimiter(opts.MaxConcurrentMounts),
+		inFlight:    map[uint64]inFlightMount{},
+		drained:     make(chan struct{}),
 		mountFunc:   mountFunc,
 		versionFunc: versionFunc,
+
+		checkAppliances: conjur.CheckAppliances,
 	}
 	v1alpha1.RegisterCSIDriverProviderServer(grpcServer, providerServer)
+	healthpb.RegisterHealthServer(grpcServer, &grpcHealthServer{provider: providerServer})
 	return providerServer
 }
 
 // Start serves the gRPC server on the default socket.
 func (c *ConjurProviderServer) Start() error {
-	return c.startWithDeps(net.Listen, c.socketPath)
+	return c.startWithDeps(listenSocket, c.socketPath)
 }
 
 func (c *ConjurProviderServer) startWithDeps(
@@ -74,30 +142,162 @@ func (c *ConjurProviderServer) startWithDeps(
 	c.listener, err = listenerFactory("unix", socketPath)
 	if err != nil {
 		log.Error(logmessages.CKCP020, err)
-		return fmt.Errorf(logmessages.CKCP020, err)
+		return logmessages.Errorf(logmessages.CKCP020, err)
 	}
 
 	log.Info(logmessages.CKCP021, socketPath)
+	c.serving.Store(true)
+	defer c.serving.Store(false)
 	return c.grpcServer.Serve(c.listener)
 }
 
-// Stop halts the gRPC server and closes the socket listener.
-func (c *ConjurProviderServer) Stop() {
+// Drain marks the server as shutting down, so that it's reported not ready
+// while in-flight requests complete, and the CSI driver's requests are sent to
+// the replacement provider instead.
+func (c *ConjurProviderServer) Drain() {
+	if !c.draining.Swap(true) {
+		log.Info(logmessages.CKCP089)
+		close(c.drained)
+	}
+}
+
+// Draining reports whether the server is shutting down.
+func (c *ConjurProviderServer) Draining() bool {
+	return c.draining.Load()
+}
+
+// Stop drains the server, then halts the gRPC server and closes the socket
+// listener, waiting for in-flight requests to complete until ctx is done. Any
+// requests still being served then are logged and abandoned, so that a hung
+// call to Conjur or Kubernetes can't hold up the provider's shutdown.
+func (c *ConjurProviderServer) Stop(ctx context.Context) {
+	c.Drain()
 	log.Info(logmessages.CKCP022)
 
-	c.grpcServer.GracefulStop()
+	start := time.Now()
+	stopped := make(chan struct{})
+	go func() {
+		c.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		c.mutex.Lock()
+		now := time.Now()
+		log.Warn(logmessages.CKCP090, now.Sub(start).Round(time.Millisecond), len(c.inFlight))
+		for _, mount := range c.inFlight {
+			log.Warn(logmessages.CKCP091, mount.name, mount.namespace, now.Sub(mount.start).Round(time.Millisecond))
+		}
+		c.mutex.Unlock()
+
+		c.grpcServer.Stop()
+		<-stopped
+	}
 
 	log.Info(logmessages.CKCP023)
 }
 
+// track records a mount request as in-flight, returning a function which
+// must be called once it has been served.
+func (c *ConjurProviderServer) track(namespace string, name string) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := c.nextID
+	c.nextID++
+	c.inFlight[id] = inFlightMount{namespace: namespace, name: name, start: time.Now()}
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.inFlight, id)
+	}
+}
+
+// Mount serves a mount request, reporting failures as gRPC status errors so
+// that the CSI driver can tell configuration errors apart from outages.
+// Requests wait for one of the server's concurrent mount slots before being
+// served.
 func (c *ConjurProviderServer) Mount(ctx context.Context, req *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
-	return c.mountFunc(ctx, req)
+	namespace, name := requestPod(req)
+	defer c.track(namespace, name)()
+
+	release, err := c.limiter.acquire(ctx, namespace)
+	if err != nil {
+		return nil, logmessages.GRPCError(err)
+	}
+	defer release()
+
+	resp, err := c.mountFunc(ctx, req)
+	if err != nil {
+		return nil, logmessages.GRPCError(err)
+	}
+	return resp, nil
 }
 
 func (c *ConjurProviderServer) Version(ctx context.Context, req *v1alpha1.VersionRequest) (*v1alpha1.VersionResponse, error) {
 	return c.versionFunc(ctx, req)
 }
 
+// listenSocket listens on a unix socket at path, readable and writable only by
+// the provider. A socket left behind by a provider which crashed is replaced,
+// but one which is still being served by another process is not.
+func listenSocket(network string, path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return nil, logmessages.Errorf(logmessages.CKCP088, path, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing is listening on it.
+func removeStaleSocket(path string) error {
+	return removeStaleSocketWithDeps(path, net.DialTimeout)
+}
+
+func removeStaleSocketWithDeps(
+	path string,
+	dial func(string, string, time.Duration) (net.Conn, error),
+) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return logmessages.Errorf(logmessages.CKCP087, path)
+	}
+
+	conn, err := dial("unix", path, staleSocketTimeout)
+	if err == nil {
+		conn.Close()
+		return logmessages.Errorf(logmessages.CKCP086, path)
+	}
+	// Only a refused connection shows that nothing is listening. A timeout may
+	// just mean the provider serving the socket is busy.
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return logmessages.Errorf(logmessages.CKCP093, path, err)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	log.Info(logmessages.CKCP085, path)
+	return nil
+}
+
 func validateSocket(path string) {
 	dir := filepath.Dir(path)
 	if !strings.HasPrefix(dir, "/var/run/secrets-store-csi-providers") &&
@@ -5,13 +5,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	stdlog "log"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
 )
 
@@ -33,6 +40,7 @@ func (l mockListener) Close() error {
 
 type mockGrpc struct {
 	stop            func()
+	forceStop       func()
 	registerService func(*grpc.ServiceDesc, any)
 	serve           func(net.Listener) error
 }
@@ -41,6 +49,10 @@ func (g mockGrpc) GracefulStop() {
 	g.stop()
 }
 
+func (g mockGrpc) Stop() {
+	g.forceStop()
+}
+
 func (g mockGrpc) RegisterService(sd *grpc.ServiceDesc, ss any) {
 	g.registerService(sd, ss)
 }
@@ -94,6 +106,21 @@ func TestNewServerWithDeps(t *testing.T) {
 				assert.Contains(t, err.Error(), "custom version error")
 			},
 		},
+		{
+			description: "provider server reports mount errors with gRPC status codes",
+			socketPath:  DefaultSocketPath,
+			mountFunc: func(context.Context, *v1alpha1.MountRequest) (*v1alpha1.MountResponse, error) {
+				return nil, logmessages.Errorf(logmessages.CKCP013, logmessages.Errorf(logmessages.CKCP034, "conjur.org/secrets"))
+			},
+			assertions: func(t *testing.T, c *ConjurProviderServer, logs bytes.Buffer) {
+				_, err := c.Mount(context.TODO(), &v1alpha1.MountRequest{})
+
+				s, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, codes.InvalidArgument, s.Code())
+				assert.Equal(t, `CKCP013 Failed to create configuration from mount request parameters: CKCP034 Annotation "conjur.org/secrets" missing or empty`, s.Message())
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -103,6 +130,7 @@ func TestNewServerWithDeps(t *testing.T) {
 
 			p := newServerWithDeps(
 				tc.socketPath,
+				ServerOptions{},
 				func(opt ...grpc.ServerOption) grpcServer {
 					return mockGrpc{
 						stop:            func() {},
@@ -170,31 +198,165 @@ func TestStart(t *testing.T) {
 				}
 			}
 
-			p := newServerWithDeps("", grpcFactory, nil, nil)
+			p := newServerWithDeps("", ServerOptions{}, grpcFactory, nil, nil)
 			err := p.startWithDeps(tc.listenerFactory, "")
 			tc.assertions(t, err)
 		})
 	}
 }
 
+func TestListenSocket(t *testing.T) {
+	testCases := []struct {
+		description string
+		setup       func(*testing.T, string)
+		assertions  func(*testing.T, string, net.Listener, error, string)
+	}{
+		{
+			description: "no existing socket",
+			setup:       func(*testing.T, string) {},
+			assertions: func(t *testing.T, path string, listener net.Listener, err error, logs string) {
+				assert.Nil(t, err)
+				info, err := os.Stat(path)
+				assert.Nil(t, err)
+				assert.Equal(t, fs.ModeSocket|socketMode, info.Mode())
+				assert.NotContains(t, logs, "CKCP085")
+			},
+		},
+		{
+			description: "stale socket",
+			setup: func(t *testing.T, path string) {
+				listener, err := net.Listen("unix", path)
+				assert.Nil(t, err)
+				// Leave the socket behind, as a crashed provider would
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				listener.Close()
+			},
+			assertions: func(t *testing.T, path string, listener net.Listener, err error, logs string) {
+				assert.Nil(t, err)
+				assert.Contains(t, logs, fmt.Sprintf("CKCP085 Removed stale socket %s", path))
+
+				conn, err := net.Dial("unix", path)
+				assert.Nil(t, err)
+				conn.Close()
+			},
+		},
+		{
+			description: "socket in use",
+			setup: func(t *testing.T, path string) {
+				listener, err := net.Listen("unix", path)
+				assert.Nil(t, err)
+				t.Cleanup(func() { listener.Close() })
+			},
+			assertions: func(t *testing.T, path string, listener net.Listener, err error, logs string) {
+				assert.Nil(t, listener)
+				assert.EqualError(t, err, fmt.Sprintf("CKCP086 Socket %s is in use by another process", path))
+
+				conn, err := net.Dial("unix", path)
+				assert.Nil(t, err)
+				conn.Close()
+			},
+		},
+		{
+			description: "existing file isn't a socket",
+			setup: func(t *testing.T, path string) {
+				assert.Nil(t, os.WriteFile(path, []byte("data"), 0o644))
+			},
+			assertions: func(t *testing.T, path string, listener net.Listener, err error, logs string) {
+				assert.Nil(t, listener)
+				assert.EqualError(t, err, fmt.Sprintf("CKCP087 Refusing to replace %s, which isn't a socket", path))
+
+				content, err := os.ReadFile(path)
+				assert.Nil(t, err)
+				assert.Equal(t, "data", string(content))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var logBuffer bytes.Buffer
+			log.InfoLogger = stdlog.New(&logBuffer, "", 0)
+
+			path := filepath.Join(t.TempDir(), "conjur.sock")
+			tc.setup(t, path)
+
+			listener, err := listenSocket("unix", path)
+			if listener != nil {
+				defer listener.Close()
+			}
+			tc.assertions(t, path, listener, err, logBuffer.String())
+		})
+	}
+}
+
+func TestRemoveStaleSocketDialError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conjur.sock")
+	listener, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	dialErr := &net.OpError{Op: "dial", Net: "unix", Err: os.ErrDeadlineExceeded}
+	err = removeStaleSocketWithDeps(path, func(string, string, time.Duration) (net.Conn, error) {
+		return nil, dialErr
+	})
+
+	assert.EqualError(t, err, fmt.Sprintf("CKCP093 Failed to check whether socket %s is in use: %v", path, dialErr))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	_, err = os.Lstat(path)
+	assert.Nil(t, err)
+}
+
 func TestStop(t *testing.T) {
 	testCases := []struct {
 		description string
-		assertions  func(*testing.T)
+		// hung leaves graceful stops waiting for a force stop, as they would
+		// for a mount request which never completes
+		hung       bool
+		assertions func(*testing.T, *ConjurProviderServer, bool, string)
 	}{
 		{
-			description: "happy path",
-			assertions: func(t *testing.T) {
+			description: "in-flight requests complete",
+			assertions: func(t *testing.T, p *ConjurProviderServer, forced bool, logs string) {
 				assert.True(t, stopped)
+				assert.False(t, forced)
+				assert.True(t, p.Draining())
+				assert.Contains(t, logs, "CKCP089 Draining gRPC server")
+				assert.Contains(t, logs, "CKCP023 gRPC server stopped.")
+				assert.NotContains(t, logs, "CKCP090")
+			},
+		},
+		{
+			description: "drain deadline passes",
+			hung:        true,
+			assertions: func(t *testing.T, p *ConjurProviderServer, forced bool, logs string) {
+				assert.True(t, forced)
+				assert.Contains(t, logs, "stopping 1 in-flight mount requests")
+				assert.Contains(t, logs, `CKCP091 Abandoned mount request for pod "app" in namespace "app-namespace" after`)
+				assert.Contains(t, logs, "CKCP023 gRPC server stopped.")
 			},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
+			var logBuffer bytes.Buffer
+			log.InfoLogger = stdlog.New(&logBuffer, "", 0)
+			log.ErrorLogger = stdlog.New(&logBuffer, "", 0)
+
+			forced := false
+			forceStopped := make(chan struct{})
 			grpcFactory := func(opt ...grpc.ServerOption) grpcServer {
 				return mockGrpc{
-					stop:            func() { stopped = true },
+					stop: func() {
+						if tc.hung {
+							<-forceStopped
+						}
+						stopped = true
+					},
+					forceStop: func() {
+						forced = true
+						close(forceStopped)
+					},
 					registerService: func(sd *grpc.ServiceDesc, ss any) {},
 					serve:           func(lis net.Listener) error { return nil },
 				}
@@ -203,13 +365,18 @@ func TestStop(t *testing.T) {
 				return mockListener{}, nil
 			}
 
-			p := newServerWithDeps("", grpcFactory, nil, nil)
+			p := newServerWithDeps("", ServerOptions{}, grpcFactory, nil, nil)
 			err := p.startWithDeps(listenerFactory, "")
 			assert.Nil(t, err)
 			stopped = false
 
-			p.Stop()
-			tc.assertions(t)
+			done := p.track("app-namespace", "app")
+			defer done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			p.Stop(ctx)
+			tc.assertions(t, p, forced, logBuffer.String())
 		})
 	}
 }
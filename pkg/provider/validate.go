@@ -0,0 +1,278 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1"
+)
+
+const csiDriverName = "secrets-store.csi.k8s.io"
+
+// defaultPermission is the CSI driver's default file permission, 0644, as
+// given in mount requests.
+const defaultPermission = "420"
+
+// Stand-ins for the values the CSI driver adds to a mount request, which
+// can't be known before a pod is scheduled.
+const (
+	validateToken       = "validate"
+	validateCertificate = "validate"
+)
+
+// Manifest is a file of Kubernetes objects in YAML or JSON, possibly
+// containing several documents.
+type Manifest struct {
+	// Name identifies the manifest in validation results, usually by its path.
+	Name string
+	Data []byte
+}
+
+// ValidationResult is the outcome of validating a pod, or the pod template
+// of a workload, against a SecretProviderClass it mounts.
+type ValidationResult struct {
+	// Subject describes the validated objects, such as
+	// `Deployment "app" with SecretProviderClass "conjur"`.
+	Subject string
+	// Err is the reason the objects would fail to mount secrets, or nil if
+	// they're valid.
+	Err error
+	// Warning is set when Err describes objects that couldn't be validated,
+	// rather than invalid ones.
+	Warning bool
+}
+
+// object holds the fields of a Kubernetes object read during validation.
+type object struct {
+	Kind     string     `yaml:"kind"`
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     struct {
+		// SecretProviderClass
+		Provider   string            `yaml:"provider"`
+		Parameters map[string]string `yaml:"parameters"`
+		// Pod
+		podSpec `yaml:",inline"`
+		// Deployment, StatefulSet, DaemonSet, ReplicaSet and Job
+		Template *podTemplate `yaml:"template"`
+		// CronJob
+		JobTemplate *struct {
+			Spec struct {
+				Template *podTemplate `yaml:"template"`
+			} `yaml:"spec"`
+		} `yaml:"jobTemplate"`
+	} `yaml:"spec"`
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type podTemplate struct {
+	Metadata objectMeta `yaml:"metadata"`
+	Spec     podSpec    `yaml:"spec"`
+}
+
+type podSpec struct {
+	Volumes []struct {
+		CSI *struct {
+			Driver           string            `yaml:"driver"`
+			VolumeAttributes map[string]string `yaml:"volumeAttributes"`
+		} `yaml:"csi"`
+	} `yaml:"volumes"`
+}
+
+// decodeManifest returns the objects in each of a manifest's documents.
+func decodeManifest(manifest Manifest) ([]*object, error) {
+	objects := []*object{}
+	decoder := yaml.NewDecoder(bytes.NewReader(manifest.Data))
+	for {
+		obj := &object{}
+		err := decoder.Decode(obj)
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, logmessages.Errorf(logmessages.CKCP068, manifest.Name, err)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// podTemplate returns the pod, or pod template of a workload, described by
+// an object, or nil if it doesn't describe one.
+func (o *object) podTemplate() *podTemplate {
+	switch o.Kind {
+	case "Pod":
+		return &podTemplate{Metadata: o.Metadata, Spec: o.Spec.podSpec}
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		return o.Spec.Template
+	case "CronJob":
+		if o.Spec.JobTemplate != nil {
+			return o.Spec.JobTemplate.Spec.Template
+		}
+	}
+	return nil
+}
+
+// secretProviderClasses returns the names of the SecretProviderClasses
+// mounted by a pod's CSI volumes.
+func (p *podTemplate) secretProviderClasses() []string {
+	names := []string{}
+	for _, volume := range p.Spec.Volumes {
+		if volume.CSI != nil && MountsSecretProviderClass(volume.CSI.Driver, volume.CSI.VolumeAttributes) {
+			names = append(names, volume.CSI.VolumeAttributes[secretProviderClassKey])
+		}
+	}
+	return names
+}
+
+// MountsSecretProviderClass reports whether a pod's CSI volume, given by its
+// driver and volume attributes, mounts a SecretProviderClass with the Secrets
+// Store CSI Driver.
+func MountsSecretProviderClass(driver string, attributes map[string]string) bool {
+	return driver == csiDriverName && attributes[secretProviderClassKey] != ""
+}
+
+// ValidateManifests checks that the pods and workloads in a set of manifests
+// would be able to mount secrets from the Conjur SecretProviderClasses they
+// use. Each pairing of a pod with a SecretProviderClass is parsed as a mount
+// request would be, without contacting Conjur or Kubernetes, so problems that
+// depend on either of them, such as missing variables or certificate
+// references to missing ConfigMaps, aren't detected. An error is only
+// returned if a manifest can't be parsed.
+func ValidateManifests(ctx context.Context, manifests []Manifest) ([]ValidationResult, error) {
+	objects := []*object{}
+	for _, manifest := range manifests {
+		manifestObjects, err := decodeManifest(manifest)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, manifestObjects...)
+	}
+
+	classes := map[string]*object{}
+	used := map[*object]bool{}
+	for _, obj := range objects {
+		if obj.Kind == "SecretProviderClass" && obj.Spec.Provider == providerName {
+			classes[obj.Metadata.Name] = obj
+		}
+	}
+
+	results := []ValidationResult{}
+	for _, obj := range objects {
+		pod := obj.podTemplate()
+		if pod == nil {
+			continue
+		}
+		namespace := obj.Metadata.Namespace
+		if namespace == "" {
+			namespace = pod.Metadata.Namespace
+		}
+
+		for _, name := range pod.secretProviderClasses() {
+			subject := fmt.Sprintf("%s %q with SecretProviderClass %q", obj.Kind, obj.Metadata.Name, name)
+			class, ok := classes[name]
+			if !ok {
+				results = append(results, ValidationResult{
+					Subject: subject,
+					Err:     logmessages.Errorf(logmessages.CKCP069, name),
+					Warning: true,
+				})
+				continue
+			}
+			used[class] = true
+
+			results = append(results, ValidationResult{
+				Subject: subject,
+				Err:     validatePod(ctx, obj.Metadata.Name, namespace, pod.Metadata.Annotations, class),
+			})
+		}
+	}
+
+	for _, obj := range objects {
+		if classes[obj.Metadata.Name] == obj && !used[obj] {
+			results = append(results, ValidationResult{
+				Subject: fmt.Sprintf("SecretProviderClass %q", obj.Metadata.Name),
+				Err:     logmessages.Errorf(logmessages.CKCP070),
+				Warning: true,
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// validatePod parses the mount request that would be made for a pod with the
+// given annotations and SecretProviderClass, returning any error that would
+// cause the mount to fail before Conjur is contacted.
+func validatePod(ctx context.Context, podName string, namespace string, annotations map[string]string, class *object) error {
+	_, err := NewConfig(
+		ctx,
+		newMountRequest(class, podName, namespace, validateToken, defaultPermission),
+		func(string, string, string) (map[string]string, error) {
+			return annotations, nil
+		},
+		// Certificates are referenced by name only, since reading them would
+		// require access to the cluster
+		func(string, string, string, string) (string, error) {
+			return validateCertificate, nil
+		},
+	)
+	return err
+}
+
+// newMountRequest returns the mount request the CSI driver would send for a
+// pod mounting a SecretProviderClass, given the pod's service account token.
+func newMountRequest(class *object, podName string, namespace string, token string, permission string) *v1alpha1.MountRequest {
+	attributes := map[string]string{}
+	for key, value := range class.Spec.Parameters {
+		attributes[key] = value
+	}
+	tokens, _ := json.Marshal(map[string]map[string]string{
+		providerName: {"token": token},
+	})
+	attributes[saTokensKey] = string(tokens)
+	attributes[podNameKey] = podName
+	attributes[podNamespaceKey] = namespace
+	attributes[secretProviderClassKey] = class.Metadata.Name
+
+	attributesJSON, _ := json.Marshal(attributes)
+	return &v1alpha1.MountRequest{
+		Attributes: string(attributesJSON),
+		Permission: permission,
+	}
+}
+
+// ValidateAnnotations checks the secrets listed by a pod's conjur.org/secrets
+// and secret group annotations, returning the error a mount request for the
+// pod would fail with if they're invalid. Pods without these annotations are
+// valid.
+func ValidateAnnotations(ctx context.Context, annotations map[string]string) error {
+	logger := logging.FromContext(ctx)
+
+	files, err := parseSecretGroups(logger, annotations)
+	if err != nil {
+		logger.Error(logmessages.CKCP011, err)
+		return logmessages.Errorf(logmessages.CKCP011, err)
+	}
+
+	if secretsStr := annotations[secretsAnnotationKey]; secretsStr != "" {
+		secretsFiles, err := parseSecrets(logger, secretsStr)
+		if err != nil {
+			logger.Error(logmessages.CKCP011, err)
+			return logmessages.Errorf(logmessages.CKCP011, err)
+		}
+		files = append(secretsFiles, files...)
+	}
+
+	return validateFilePaths(logger, files, nil)
+}
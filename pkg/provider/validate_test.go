@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validateSecretProviderClass = `
+apiVersion: secrets-store.csi.x-k8s.io/v1
+kind: SecretProviderClass
+metadata:
+  name: credentials-from-conjur
+  namespace: app-namespace
+spec:
+  provider: conjur
+  parameters:
+    conjur.org/configurationVersion: 0.2.0
+    account: default
+    applianceUrl: https://conjur.conjur-ns.svc.cluster.local
+    authnId: authn-jwt/kube
+    sslCertificateConfigMapRef: conjur-ca
+`
+
+const validateDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: app-namespace
+spec:
+  template:
+    metadata:
+      annotations:
+        conjur.org/secrets: |
+          - "relative/path/fileA.txt": "path/to/secret/A"
+    spec:
+      volumes:
+        - name: conjur-csi-provider-volume
+          csi:
+            driver: secrets-store.csi.k8s.io
+            readOnly: true
+            volumeAttributes:
+              secretProviderClass: credentials-from-conjur
+`
+
+func TestValidateManifests(t *testing.T) {
+	testCases := []struct {
+		description string
+		manifests   []Manifest
+		assertions  func(*testing.T, []ValidationResult, error)
+	}{
+		{
+			description: "valid deployment and SecretProviderClass in one manifest",
+			manifests: []Manifest{
+				{Name: "app.yaml", Data: []byte(validateSecretProviderClass + "---" + validateDeployment)},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, err)
+				assert.Equal(t, []ValidationResult{
+					{Subject: `Deployment "app" with SecretProviderClass "credentials-from-conjur"`},
+				}, results)
+			},
+		},
+		{
+			description: "pod with malformed secrets annotation",
+			manifests: []Manifest{
+				{Name: "spc.yaml", Data: []byte(validateSecretProviderClass)},
+				{Name: "pod.yaml", Data: []byte(`
+kind: Pod
+metadata:
+  name: app
+  annotations:
+    conjur.org/secrets: "- relative/path/fileA.txt: path/to/secret/A: extra"
+spec:
+  volumes:
+    - csi:
+        driver: secrets-store.csi.k8s.io
+        volumeAttributes:
+          secretProviderClass: credentials-from-conjur
+`)},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, `Pod "app" with SecretProviderClass "credentials-from-conjur"`, results[0].Subject)
+				assert.False(t, results[0].Warning)
+				assert.ErrorContains(t, results[0].Err, "CKCP011")
+			},
+		},
+		{
+			description: "SecretProviderClass missing authnId",
+			manifests: []Manifest{
+				{Name: "app.yaml", Data: []byte(`
+kind: SecretProviderClass
+metadata:
+  name: credentials-from-conjur
+spec:
+  provider: conjur
+  parameters:
+    account: default
+    applianceUrl: https://conjur.conjur-ns.svc.cluster.local
+    sslCertificate: certificate content
+---` + validateDeployment)},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, results, 1)
+				assert.ErrorContains(t, results[0].Err, "CKCP009 Missing required Conjur config attributes: [\"authnId\"]")
+			},
+		},
+		{
+			description: "cron job without secrets annotation",
+			manifests: []Manifest{
+				{Name: "app.yaml", Data: []byte(validateSecretProviderClass + `
+---
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          volumes:
+            - csi:
+                driver: secrets-store.csi.k8s.io
+                volumeAttributes:
+                  secretProviderClass: credentials-from-conjur
+`)},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, results, 1)
+				assert.Equal(t, `CronJob "report" with SecretProviderClass "credentials-from-conjur"`, results[0].Subject)
+				assert.ErrorContains(t, results[0].Err, "CKCP034")
+			},
+		},
+		{
+			description: "SecretProviderClass not provided or not used",
+			manifests: []Manifest{
+				{Name: "deployment.yaml", Data: []byte(validateDeployment)},
+				{Name: "other.yaml", Data: []byte(`
+kind: SecretProviderClass
+metadata:
+  name: other
+spec:
+  provider: conjur
+---
+kind: SecretProviderClass
+metadata:
+  name: vault
+spec:
+  provider: vault
+`)},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, err)
+				assert.Len(t, results, 2)
+				assert.True(t, results[0].Warning)
+				assert.ErrorContains(t, results[0].Err, `CKCP069 SecretProviderClass "credentials-from-conjur" was not found`)
+				assert.Equal(t, `SecretProviderClass "other"`, results[1].Subject)
+				assert.True(t, results[1].Warning)
+				assert.ErrorContains(t, results[1].Err, "CKCP070")
+			},
+		},
+		{
+			description: "malformed manifest",
+			manifests: []Manifest{
+				{Name: "broken.yaml", Data: []byte("kind: [Pod")},
+			},
+			assertions: func(t *testing.T, results []ValidationResult, err error) {
+				assert.Nil(t, results)
+				assert.ErrorContains(t, err, `CKCP068 Failed to parse manifest "broken.yaml"`)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			results, err := ValidateManifests(context.Background(), tc.manifests)
+			tc.assertions(t, results, err)
+		})
+	}
+}
+
+func TestValidateAnnotations(t *testing.T) {
+	testCases := []struct {
+		description string
+		annotations map[string]string
+		expectedErr string
+	}{
+		{
+			description: "no Conjur annotations",
+			annotations: map[string]string{"app": "test"},
+		},
+		{
+			description: "valid secrets and secret group",
+			annotations: map[string]string{
+				"conjur.org/secrets":               "- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n",
+				"conjur.org/conjur-secrets.db":     "- path/to/secret/B\n",
+				"conjur.org/secret-file-format.db": "dotenv",
+				"conjur.org/secret-file-path.db":   "relative/path/db.env",
+			},
+		},
+		{
+			description: "malformed secrets",
+			annotations: map[string]string{"conjur.org/secrets": "- relative/path/fileA.txt: path/to/secret/A: extra"},
+			expectedErr: "CKCP011 Failed to unmarshal secrets spec: CKCP033 Failed to unmarshal YAML",
+		},
+		{
+			description: "absolute path",
+			annotations: map[string]string{"conjur.org/secrets": "- \"/etc/fileA.txt\": \"path/to/secret/A\"\n"},
+			expectedErr: `CKCP076 Invalid secret file path "/etc/fileA.txt": absolute paths aren't allowed`,
+		},
+		{
+			description: "path escaping the mount",
+			annotations: map[string]string{"conjur.org/secrets": "- \"relative/../../fileA.txt\": \"path/to/secret/A\"\n"},
+			expectedErr: `CKCP076 Invalid secret file path "relative/../../fileA.txt": path refers outside of the mount`,
+		},
+		{
+			description: "path of the mount itself",
+			annotations: map[string]string{"conjur.org/secrets": "- \"relative/..\": \"path/to/secret/A\"\n"},
+			expectedErr: `CKCP076 Invalid secret file path "relative/..": path refers to the mount itself`,
+		},
+		{
+			description: "policy branch in the root of the mount",
+			annotations: map[string]string{"conjur.org/secrets": "- \".\": \"db-credentials/*\"\n"},
+		},
+		{
+			description: "path reserved by the CSI driver",
+			annotations: map[string]string{"conjur.org/secrets": "- \"..data/fileA.txt\": \"path/to/secret/A\"\n"},
+			expectedErr: `CKCP076 Invalid secret file path "..data/fileA.txt": paths beginning with ".." are reserved by the CSI driver`,
+		},
+		{
+			description: "duplicate path",
+			annotations: map[string]string{
+				"conjur.org/secrets":             "- \"relative/path/db.yaml\": \"path/to/secret/A\"\n",
+				"conjur.org/conjur-secrets.db":   "- path/to/secret/B\n",
+				"conjur.org/secret-file-path.db": "relative/./path/db.yaml",
+			},
+			expectedErr: `CKCP076 Invalid secret file path "relative/./path/db.yaml": path is used by more than one file`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := ValidateAnnotations(context.Background(), tc.annotations)
+			if tc.expectedErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStartDisabled(t *testing.T) {
+	stop, err := Start(context.Background(), Config{}, "0.0.test")
+
+	assert.Nil(t, err)
+	assert.Nil(t, stop(context.Background()))
+}
+
+func TestEnd(t *testing.T) {
+	testCases := []struct {
+		description    string
+		err            error
+		expectedStatus codes.Code
+		expectedEvents int
+	}{
+		{
+			description:    "successful span",
+			expectedStatus: codes.Unset,
+		},
+		{
+			description:    "failed span",
+			err:            errors.New("CKCP031 Failed to retrieve batch secrets"),
+			expectedStatus: codes.Error,
+			expectedEvents: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			_, span := provider.Tracer(tracerName).Start(context.Background(), "test")
+
+			End(span, tc.err)
+
+			spans := recorder.Ended()
+			assert.Len(t, spans, 1)
+			assert.Equal(t, tc.expectedStatus, spans[0].Status().Code)
+			assert.Len(t, spans[0].Events(), tc.expectedEvents)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logging"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/logmessages"
+	"github.com/cyberark/conjur-k8s-csi-provider/pkg/provider"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const DefaultPort int = 8443
+
+// maxReviewSize limits the size of admission review request bodies, which is
+// well above the size of any pod the Kubernetes API accepts.
+const maxReviewSize = 4 << 20
+
+// Server serves a validating admission webhook on /validate, which rejects
+// pods whose Conjur secrets annotations would cause their mount requests to
+// fail, along with a /healthz endpoint. Both are served over TLS, as required
+// for admission webhooks.
+type Server struct {
+	port     int
+	certFile string
+	keyFile  string
+	server   *http.Server
+}
+
+// NewServer creates a Server listening on the given port, using the
+// certificate and private key in the given files.
+func NewServer(port int, certFile string, keyFile string) *Server {
+	return newServerWithDeps(port, certFile, keyFile, provider.ValidateAnnotations)
+}
+
+func newServerWithDeps(
+	port int,
+	certFile string,
+	keyFile string,
+	validateFunc func(context.Context, map[string]string) error,
+) *Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", validateHandler(validateFunc))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return &Server{
+		port:     port,
+		certFile: certFile,
+		keyFile:  keyFile,
+		server: &http.Server{
+			Addr:    fmt.Sprintf(":%d", port),
+			Handler: mux,
+		},
+	}
+}
+
+// Start serves the webhook on the Server's port.
+func (s *Server) Start() error {
+	log.Info(logmessages.CKCP077, s.port)
+	return s.server.ListenAndServeTLS(s.certFile, s.keyFile)
+}
+
+// Stop gracefully shuts down the webhook, waiting for in-flight reviews to
+// complete until ctx is done.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// validateHandler handles AdmissionReview requests sent by the Kubernetes API
+// server for pods being created.
+func validateHandler(validateFunc func(context.Context, map[string]string) error) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var review admissionv1.AdmissionReview
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxReviewSize))
+		if err == nil {
+			err = json.Unmarshal(body, &review)
+		}
+		if err == nil && review.Request == nil {
+			err = fmt.Errorf("review contains no request")
+		}
+		if err != nil {
+			log.Error(logmessages.CKCP080, err)
+			http.Error(w, logmessages.Errorf(logmessages.CKCP080, err).Error(), http.StatusBadRequest)
+			return
+		}
+
+		review.Response = reviewPod(req.Context(), review.Request, validateFunc)
+		review.Request = nil
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	}
+}
+
+// reviewPod allows a pod to be admitted unless its annotations are invalid.
+// Other kinds of objects are always allowed, as are pods which don't mount a
+// SecretProviderClass, since other Conjur integrations, such as Secrets
+// Provider for Kubernetes, share some of the provider's annotations.
+func reviewPod(
+	ctx context.Context,
+	req *admissionv1.AdmissionRequest,
+	validateFunc func(context.Context, map[string]string) error,
+) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Group != "" || req.Kind.Kind != "Pod" {
+		return resp
+	}
+
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		log.Error(logmessages.CKCP080, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: logmessages.Errorf(logmessages.CKCP080, err).Error(),
+		}
+		return resp
+	}
+
+	if !mountsSecretProviderClass(&pod) {
+		return resp
+	}
+
+	// Pods created by controllers are only given a name once admitted
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+	logger := (&logging.Logger{}).
+		With(logging.PodKey, podName).
+		With(logging.NamespaceKey, req.Namespace)
+
+	if err := validateFunc(logging.NewContext(ctx, logger), pod.Annotations); err != nil {
+		logger.Info(logmessages.CKCP081, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		}
+	}
+	return resp
+}
+
+// mountsSecretProviderClass reports whether any of a pod's volumes are
+// mounted by the Secrets Store CSI Driver.
+func mountsSecretProviderClass(pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.CSI != nil && provider.MountsSecretProviderClass(volume.CSI.Driver, volume.CSI.VolumeAttributes) {
+			return true
+		}
+	}
+	return false
+}
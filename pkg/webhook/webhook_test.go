@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cyberark/conjur-authn-k8s-client/pkg/log"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func reviewBody(t *testing.T, kind string, object string) string {
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+			Namespace: "app-namespace",
+			Object:    runtime.RawExtension{Raw: []byte(object)},
+		},
+	}
+	body, err := json.Marshal(review)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+// secretsStoreVolume is the spec of a pod mounting a SecretProviderClass.
+const secretsStoreVolume = `"spec":{"volumes":[{"name":"secrets","csi":{
+	"driver":"secrets-store.csi.k8s.io","volumeAttributes":{"secretProviderClass":"conjur"}}}]}`
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		description string
+		body        string
+		assertions  func(*testing.T, *httptest.ResponseRecorder)
+	}{
+		{
+			description: "valid pod",
+			body: reviewBody(t, "Pod", `{"metadata":{"name":"app","annotations":{
+				"conjur.org/secrets":"- \"relative/path/fileA.txt\": \"path/to/secret/A\"\n"}},`+secretsStoreVolume+`}`),
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusOK, recorder.Code)
+
+				var review admissionv1.AdmissionReview
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+				assert.Equal(t, "AdmissionReview", review.Kind)
+				assert.Nil(t, review.Request)
+				assert.Equal(t, "705ab4f5-6393-11e8-b7cc-42010a800002", string(review.Response.UID))
+				assert.True(t, review.Response.Allowed)
+			},
+		},
+		{
+			description: "pod with escaping path",
+			body: reviewBody(t, "Pod", `{"metadata":{"generateName":"app-","annotations":{
+				"conjur.org/secrets":"- \"../fileA.txt\": \"path/to/secret/A\"\n"}},`+secretsStoreVolume+`}`),
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var review admissionv1.AdmissionReview
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+				assert.False(t, review.Response.Allowed)
+				assert.Equal(t, int32(http.StatusUnprocessableEntity), review.Response.Result.Code)
+				assert.Equal(t, `CKCP076 Invalid secret file path "../fileA.txt": path refers outside of the mount`, review.Response.Result.Message)
+			},
+		},
+		{
+			description: "pod with malformed secrets",
+			body: reviewBody(t, "Pod", `{"metadata":{"name":"app","annotations":{
+				"conjur.org/secrets":"- relative/path/fileA.txt: path/to/secret/A: extra"}},`+secretsStoreVolume+`}`),
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var review admissionv1.AdmissionReview
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+				assert.False(t, review.Response.Allowed)
+				assert.Contains(t, review.Response.Result.Message, "CKCP011")
+			},
+		},
+		{
+			description: "pod without a SecretProviderClass volume",
+			body: reviewBody(t, "Pod", `{"metadata":{"name":"app","annotations":{
+				"conjur.org/secrets":"- \"../fileA.txt\": \"path/to/secret/A\"\n"}},"spec":{"volumes":[{"name":"other","csi":{
+				"driver":"other.csi.k8s.io","volumeAttributes":{"secretProviderClass":"conjur"}}}]}}`),
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var review admissionv1.AdmissionReview
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+				assert.True(t, review.Response.Allowed)
+			},
+		},
+		{
+			description: "other kind",
+			body:        reviewBody(t, "ConfigMap", `{"metadata":{"annotations":{"conjur.org/secrets":"invalid: ["}}}`),
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				var review admissionv1.AdmissionReview
+				assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &review))
+				assert.True(t, review.Response.Allowed)
+			},
+		},
+		{
+			description: "malformed review",
+			body:        "{",
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusBadRequest, recorder.Code)
+				assert.Contains(t, recorder.Body.String(), "CKCP080 Failed to decode admission review")
+			},
+		},
+		{
+			description: "review without request",
+			body:        `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			assertions: func(t *testing.T, recorder *httptest.ResponseRecorder) {
+				assert.Equal(t, http.StatusBadRequest, recorder.Code)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			server := NewServer(DefaultPort, "tls.crt", "tls.key")
+			recorder := httptest.NewRecorder()
+			server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(tc.body)))
+			tc.assertions(t, recorder)
+		})
+	}
+}
+
+func TestValidateLogFields(t *testing.T) {
+	var logBuffer bytes.Buffer
+	log.InfoLogger = stdlog.New(&logBuffer, "", 0)
+	server := newServerWithDeps(DefaultPort, "tls.crt", "tls.key", func(ctx context.Context, annotations map[string]string) error {
+		return errors.New("invalid")
+	})
+
+	recorder := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(
+		reviewBody(t, "Pod", `{"metadata":{"name":"app"},`+secretsStoreVolume+`}`),
+	)))
+
+	assert.Contains(t, logBuffer.String(), `CKCP081 Rejected pod: invalid pod="app" namespace="app-namespace"`)
+}
+
+func TestHealthz(t *testing.T) {
+	server := NewServer(DefaultPort, "tls.crt", "tls.key")
+	recorder := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}